@@ -2,49 +2,68 @@ package app
 
 import (
 	"log"
+	"log/slog"
 	"os"
 	"seventv2tg/internal/config"
 	"seventv2tg/internal/handler"
+	"seventv2tg/internal/infrastructure/storage"
 	"seventv2tg/internal/infrastructure/webapi"
 	"seventv2tg/internal/server"
 	"seventv2tg/internal/service"
 )
 
 type App struct {
-	cfg    *config.Config
-	server *server.Server
+	cfg      *config.Config
+	server   *server.Server
+	storages *storage.Storages
 }
 
 func New(cfg *config.Config) *App {
+	app := &App{
+		cfg: cfg,
+	}
+
+	if cfg.Debug {
+		slog.SetLogLoggerLevel(slog.LevelDebug)
+	}
+
+	// Рабочие папки чистим до запуска воркеров, иначе они могут удалить файлы уже взятых задач.
+	err := app.setupDirs()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	storages, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	webAPI := webapi.New(cfg)
 
-	services := service.New(cfg)
+	services := service.New(cfg, storages, webAPI)
 
-	handlers := handler.New(cfg, webAPI, services)
+	handlers := handler.New(cfg, webAPI, services, storages)
 
-	s := server.New(
+	app.storages = storages
+	app.server = server.New(
 		&server.InitParams{
 			Config:   cfg,
 			Api:      webAPI.TgBot,
 			Handlers: handlers,
+			Services: services,
 		},
 	)
 
-	app := &App{
-		cfg:    cfg,
-		server: s,
-	}
-
-	err := app.setupDirs()
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	return app
 }
 
 func (a *App) Run() {
 	a.server.Start()
+
+	err := a.storages.Close()
+	if err != nil {
+		log.Println(err)
+	}
 }
 
 func (a *App) setupDirs() error {
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/pkg/errors"
@@ -15,9 +16,21 @@ const (
 	inputDirName  = "input"
 	jobsDirName   = "jobs"
 	resultDirName = "output"
+	dataDirName   = "data"
 
 	mediaWorkerCount      = 3
 	ffmpegRendererThreads = 3
+
+	maxQueueSize = 50
+
+	jobHistoryDays = 30
+)
+
+const (
+	AccessModeOpen      = "open"
+	AccessModeAllowlist = "allowlist"
+	AccessModeGroup     = "group"
+	AccessModeInvite    = "invite"
 )
 
 type (
@@ -25,14 +38,42 @@ type (
 		BotApiKey             string  `yaml:"bot_api_key"`
 		Debug                 bool    `yaml:"debug"`
 		AdminIDs              []int64 `yaml:"admin_ids"`
+		PriorityUserIDs       []int64 `yaml:"priority_user_ids"`
+		MaintenanceTesterIDs  []int64 `yaml:"maintenance_tester_ids"`
 		Paths                 Paths
 		MediaWorkersCount     int `yaml:"media_workers_count"`
 		FfmpegRendererThreads int `yaml:"ffmpeg_renderer_threads"`
+		RateLimits            RateLimits
+		Admission             Admission
+		Access                Access
+		// JobHistoryDays is how long finished jobs are kept for /stats, zero keeps them forever.
+		JobHistoryDays int `yaml:"job_history_days"`
+		// CaptionFonts are fallback fonts for caption glyphs missing in the bundled one, checked before
+		// the bundled monochrome emoji font. Only TTF/OTF/TTC with glyf or CFF outlines are supported,
+		// color bitmap fonts (CBDT, sbix) like Noto Color Emoji have no outlines and draw nothing.
+		CaptionFonts []string `yaml:"caption_fonts"`
+	}
+	// RateLimits are applied per user, zero value disables the limit.
+	RateLimits struct {
+		JobsPerMinute     int `yaml:"jobs_per_minute"`
+		JobsPerDay        int `yaml:"jobs_per_day"`
+		OverlayJobsPerDay int `yaml:"overlay_jobs_per_day"`
+	}
+	// Admission thresholds for new jobs, zero value disables the check.
+	Admission struct {
+		MaxQueueSize int           `yaml:"max_queue_size"`
+		MaxQueueWait time.Duration `yaml:"max_queue_wait"`
+	}
+	// Access restricts who can use media workers, mode can be changed at runtime by admins.
+	Access struct {
+		Mode    string `yaml:"access_mode"`
+		GroupID int64  `yaml:"access_group_id"`
 	}
 	Paths struct {
 		Input  string
 		Jobs   string
 		Result string
+		Data   string
 	}
 )
 
@@ -44,9 +85,17 @@ func NewConfig(cfgFolderPath string) (*Config, error) {
 			Input:  inputDirName,
 			Jobs:   jobsDirName,
 			Result: resultDirName,
+			Data:   dataDirName,
 		},
 		MediaWorkersCount:     mediaWorkerCount,
 		FfmpegRendererThreads: ffmpegRendererThreads,
+		JobHistoryDays:        jobHistoryDays,
+		Admission: Admission{
+			MaxQueueSize: maxQueueSize,
+		},
+		Access: Access{
+			Mode: AccessModeOpen,
+		},
 	}
 
 	envPath := filepath.Join(cfgFolderPath, "app.env")
@@ -72,19 +121,56 @@ func (c *Config) loadEnv(filePath string) error {
 
 	c.BotApiKey = os.Getenv("bot_api_key")
 	c.Debug, _ = strconv.ParseBool(os.Getenv("debug"))
-	c.AdminIDs = parseAdminIds(os.Getenv("admin_ids"))
+	c.AdminIDs = parseIds(os.Getenv("admin_ids"))
+	c.PriorityUserIDs = parseIds(os.Getenv("priority_user_ids"))
+	c.MaintenanceTesterIDs = parseIds(os.Getenv("maintenance_tester_ids"))
 	c.MediaWorkersCount, _ = strconv.Atoi(os.Getenv("media_workers_count"))
 	c.FfmpegRendererThreads, _ = strconv.Atoi(os.Getenv("ffmpeg_renderer_threads"))
+	c.RateLimits.JobsPerMinute, _ = strconv.Atoi(os.Getenv("jobs_per_minute"))
+	c.RateLimits.JobsPerDay, _ = strconv.Atoi(os.Getenv("jobs_per_day"))
+	c.RateLimits.OverlayJobsPerDay, _ = strconv.Atoi(os.Getenv("overlay_jobs_per_day"))
+	c.Access.GroupID, _ = strconv.ParseInt(os.Getenv("access_group_id"), 10, 64)
+	c.CaptionFonts = parseList(os.Getenv("caption_fonts"))
+
+	if mode := os.Getenv("access_mode"); mode != "" {
+		c.Access.Mode = mode
+	}
+
+	// не заданные ключи оставляют значения по умолчанию
+	if v := os.Getenv("max_queue_size"); v != "" {
+		c.Admission.MaxQueueSize, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return errors.Wrap(errors.Errorf("invalid max_queue_size %q", v), "loadEnv")
+		}
+	}
+
+	if v := os.Getenv("max_queue_wait"); v != "" {
+		c.Admission.MaxQueueWait, err = time.ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return errors.Wrap(errors.Errorf("invalid max_queue_wait %q", v), "loadEnv")
+		}
+	}
+
+	if v := os.Getenv("job_history_days"); v != "" {
+		c.JobHistoryDays, err = strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			return errors.Wrap(errors.Errorf("invalid job_history_days %q", v), "loadEnv")
+		}
+	}
 
 	return nil
 }
 
-func parseAdminIds(inp string) (res []int64) {
-	adminIdsStr := strings.Split(inp, ",")
-	for i := range adminIdsStr {
-		id, err := strconv.ParseInt(strings.TrimSpace(adminIdsStr[i]), 10, 64)
+func parseIds(inp string) (res []int64) {
+	idsStr := strings.Split(inp, ",")
+	for i := range idsStr {
+		if strings.TrimSpace(idsStr[i]) == "" {
+			continue
+		}
+
+		id, err := strconv.ParseInt(strings.TrimSpace(idsStr[i]), 10, 64)
 		if err != nil {
-			log.Printf("Invalid admin id: %s", adminIdsStr[i])
+			log.Printf("Invalid user id: %s", idsStr[i])
 			continue
 		}
 
@@ -94,6 +180,16 @@ func parseAdminIds(inp string) (res []int64) {
 	return res
 }
 
+func parseList(inp string) (res []string) {
+	for _, item := range strings.Split(inp, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			res = append(res, item)
+		}
+	}
+
+	return res
+}
+
 func (c *Config) validate() error {
 	if c.BotApiKey == "" {
 		err := errors.New("bot_api_key is required")
@@ -107,5 +203,26 @@ func (c *Config) validate() error {
 		return errors.Wrap(err, "validate")
 	}
 
+	if !ValidAccessMode(c.Access.Mode) {
+		err := errors.New("unknown access_mode " + c.Access.Mode)
+
+		return errors.Wrap(err, "validate")
+	}
+
+	if c.Access.Mode == AccessModeGroup && c.Access.GroupID == 0 {
+		err := errors.New("access_group_id is required for group access mode")
+
+		return errors.Wrap(err, "validate")
+	}
+
 	return nil
 }
+
+func ValidAccessMode(mode string) bool {
+	switch mode {
+	case AccessModeOpen, AccessModeAllowlist, AccessModeGroup, AccessModeInvite:
+		return true
+	}
+
+	return false
+}
@@ -1,18 +1,245 @@
 package domain
 
+import "time"
+
 type UserRequest struct {
+	JobID            uint64
+	UserID           int64
 	ChatID           int64
 	ReplyToMessageID int
 	EmoteIDs         []string
+	Options          ConvertOptions
 	ErrChan          chan error
 }
 
+// FitStrategy defines how emotes longer than sticker duration limit are shortened.
+type FitStrategy string
+
+const (
+	FitTruncate   FitStrategy = "truncate"
+	FitSpeedUp    FitStrategy = "speed"
+	FitDropFrames FitStrategy = "drop"
+	FitBestWindow FitStrategy = "window"
+)
+
+func (f FitStrategy) Valid() bool {
+	switch f {
+	case FitTruncate, FitSpeedUp, FitDropFrames, FitBestWindow:
+		return true
+	}
+
+	return false
+}
+
+// Layout defines how multiple emotes are composed.
+type Layout string
+
+const (
+	// LayoutStack overlays emotes on top of the first one.
+	LayoutStack      Layout = "stack"
+	LayoutHorizontal Layout = "horizontal"
+	LayoutVertical   Layout = "vertical"
+	LayoutGrid       Layout = "grid"
+)
+
+func (l Layout) Valid() bool {
+	switch l {
+	case LayoutStack, LayoutHorizontal, LayoutVertical, LayoutGrid:
+		return true
+	}
+
+	return false
+}
+
+// OutputFormat is the name of the file type emotes are converted to.
+type OutputFormat string
+
+type CaptionPosition string
+
+const (
+	CaptionTop    CaptionPosition = "top"
+	CaptionBottom CaptionPosition = "bottom"
+)
+
+type Caption struct {
+	Text     string          `json:"text,omitempty"`
+	Position CaptionPosition `json:"position,omitempty"`
+}
+
+type ConvertOptions struct {
+	Fit     FitStrategy  `json:"fit,omitempty"`
+	Format  OutputFormat `json:"format,omitempty"`
+	Layout  Layout       `json:"layout,omitempty"`
+	Caption Caption      `json:"caption"`
+	// Layers are options of emotes in link order.
+	Layers []LayerOptions `json:"layers,omitempty"`
+}
+
+type LayerOptions struct {
+	Placement Placement `json:"placement"`
+	Modifiers Modifiers `json:"modifiers"`
+}
+
+// Modifiers are transformations applied to a single emote, like 7TV/Chatterino ones.
+type Modifiers struct {
+	FlipH bool `json:"flip_h,omitempty"`
+	FlipV bool `json:"flip_v,omitempty"`
+	// Rotate is clockwise rotation in degrees: 0, 90, 180 or 270.
+	Rotate  int  `json:"rotate,omitempty"`
+	Reverse bool `json:"reverse,omitempty"`
+	// Speed multiplier, 0 means 1.
+	Speed     float64 `json:"speed,omitempty"`
+	Hue       int     `json:"hue,omitempty"`
+	Grayscale bool    `json:"grayscale,omitempty"`
+	PingPong  bool    `json:"ping_pong,omitempty"`
+}
+
+// Preferences are per-user defaults for conversion options.
+type Preferences struct {
+	Fit    FitStrategy  `json:"fit,omitempty"`
+	Format OutputFormat `json:"format,omitempty"`
+}
+
 type EmotePaths struct {
 	Webp string
 	Webm string
 }
 
+type EmoteInfo struct {
+	ID   string
+	Name string
+	// ZeroWidth emotes are meant to be drawn over the previous emote.
+	ZeroWidth bool
+}
+
 type EmoteLayer struct {
-	WebmPath string
-	Duration float64
+	WebmPath  string
+	Duration  float64
+	Placement Placement
+}
+
+// Anchor is the point of the base layer the overlay is aligned to.
+type Anchor string
+
+const (
+	AnchorCenter      Anchor = "center"
+	AnchorTop         Anchor = "top"
+	AnchorBottom      Anchor = "bottom"
+	AnchorLeft        Anchor = "left"
+	AnchorRight       Anchor = "right"
+	AnchorTopLeft     Anchor = "top-left"
+	AnchorTopRight    Anchor = "top-right"
+	AnchorBottomLeft  Anchor = "bottom-left"
+	AnchorBottomRight Anchor = "bottom-right"
+)
+
+func (a Anchor) Valid() bool {
+	switch a {
+	case AnchorCenter, AnchorTop, AnchorBottom, AnchorLeft, AnchorRight,
+		AnchorTopLeft, AnchorTopRight, AnchorBottomLeft, AnchorBottomRight:
+		return true
+	}
+
+	return false
+}
+
+// Offset is a shift in pixels or, if Percent is set, in percents of the base layer size.
+type Offset struct {
+	Value   float64 `json:"value"`
+	Percent bool    `json:"percent,omitempty"`
+}
+
+// Placement positions a layer over the base one. Zero value keeps the layer stretched over the base.
+type Placement struct {
+	Anchor Anchor `json:"anchor,omitempty"`
+	// Scale relative to the base layer size, 0 means 1.
+	Scale   float64 `json:"scale,omitempty"`
+	OffsetX Offset  `json:"offset_x"`
+	OffsetY Offset  `json:"offset_y"`
+	// Z overrides drawing order, layers with equal Z are drawn in link order.
+	Z int `json:"z,omitempty"`
+	// MatchHeight keeps layer aspect ratio scaling it to the base layer height instead of stretching.
+	MatchHeight bool `json:"match_height,omitempty"`
+}
+
+type JobStatus string
+
+const (
+	JobStatusQueued   JobStatus = "queued"
+	JobStatusRunning  JobStatus = "running"
+	JobStatusDone     JobStatus = "done"
+	JobStatusFailed   JobStatus = "failed"
+	JobStatusCanceled JobStatus = "canceled"
+)
+
+type Job struct {
+	ID               uint64         `json:"id"`
+	ChatID           int64          `json:"chat_id"`
+	UserID           int64          `json:"user_id"`
+	ReplyToMessageID int            `json:"reply_to_message_id"`
+	EmoteIDs         []string       `json:"emote_ids"`
+	Options          ConvertOptions `json:"options"`
+	Status           JobStatus      `json:"status"`
+	Error            string         `json:"error,omitempty"`
+	CreatedAt        time.Time      `json:"created_at"`
+	StartedAt        time.Time      `json:"started_at,omitzero"`
+	FinishedAt       time.Time      `json:"finished_at,omitzero"`
+}
+
+func (j *Job) Request() UserRequest {
+	return UserRequest{
+		JobID:            j.ID,
+		UserID:           j.UserID,
+		ChatID:           j.ChatID,
+		ReplyToMessageID: j.ReplyToMessageID,
+		EmoteIDs:         j.EmoteIDs,
+		Options:          j.Options,
+		ErrChan:          make(chan error),
+	}
+}
+
+type RateBucket struct {
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// UserQuota holds token buckets of a single user keyed by limit name.
+type UserQuota struct {
+	Buckets map[string]RateBucket `json:"buckets"`
+}
+
+type JobStats struct {
+	Done        int
+	Failed      int
+	Canceled    int
+	Queued      int
+	Running     int
+	AvgDuration time.Duration // average processing time of done jobs
+}
+
+type User struct {
+	ID          int64     `json:"id"`
+	ChatID      int64     `json:"chat_id"`
+	Username    string    `json:"username,omitempty"`
+	Banned      bool      `json:"banned,omitempty"`
+	Allowed     bool      `json:"allowed,omitempty"` // granted access in restricted access modes
+	FirstSeenAt time.Time `json:"first_seen_at"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+}
+
+type Maintenance struct {
+	Enabled bool      `json:"enabled"`
+	Reason  string    `json:"reason,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+	ETA     time.Time `json:"eta,omitzero"`
+	// chats that were turned away and have to be notified when maintenance ends
+	TurnedAway []int64 `json:"turned_away,omitempty"`
+}
+
+type Invite struct {
+	Code      string    `json:"code"`
+	CreatedBy int64     `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UsedBy    int64     `json:"used_by,omitempty"`
+	UsedAt    time.Time `json:"used_at,omitzero"`
 }
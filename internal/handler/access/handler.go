@@ -0,0 +1,127 @@
+package access
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/config"
+	"seventv2tg/internal/handler/response"
+	"seventv2tg/internal/infrastructure/storage/invites"
+	"seventv2tg/internal/service"
+	accesssvc "seventv2tg/internal/service/access"
+)
+
+type (
+	botApi interface {
+		SendMessage(chatID int64, message string) (tgbotapi.Message, error)
+	}
+
+	Handler struct {
+		cfg      *config.Config
+		api      botApi
+		services *service.Services
+	}
+)
+
+func New(cfg *config.Config, botAPI botApi, services *service.Services) *Handler {
+	return &Handler{
+		cfg:      cfg,
+		api:      botAPI,
+		services: services,
+	}
+}
+
+// HasAccess checks the user against current access mode. Users without access get an explanation.
+func (h *Handler) HasAccess(userID, chatID int64) bool {
+	ok, err := h.services.Access.HasAccess(userID)
+	if err != nil {
+		slog.Error("AccessHandler.HasAccess", slog.Int64("userID", userID), slog.Any("err", err.Error()))
+		_, _ = h.api.SendMessage(chatID, "Failed to check your access, please try again later")
+
+		return false
+	}
+
+	if ok {
+		return true
+	}
+
+	message := "This bot is private. Ask the bot admin for an invite code and send /start <code>"
+	if h.services.Access.Mode() == config.AccessModeGroup {
+		message = "This bot is available only to members of a private group"
+	}
+
+	_, _ = h.api.SendMessage(chatID, message)
+
+	return false
+}
+
+// RedeemInvite handles "/start <code>".
+func (h *Handler) RedeemInvite(userID, chatID int64, code string) {
+	err := h.services.Access.RedeemInvite(userID, strings.TrimSpace(code))
+	switch {
+	case err == nil:
+		_, _ = h.api.SendMessage(chatID, "Invite code accepted, you now have access to the bot")
+	case errors.Is(err, invites.ErrNotFound), errors.Is(err, invites.ErrAlreadyUsed):
+		_, _ = h.api.SendMessage(chatID, "Invite code is invalid or was already used")
+	default:
+		response.InternalError(h.api, chatID, "AccessHandler.RedeemInvite", err)
+	}
+}
+
+// ModeResponse handles "/access [mode]".
+func (h *Handler) ModeResponse(chatID int64, args string) {
+	mode := strings.TrimSpace(args)
+	if mode == "" {
+		_, _ = h.api.SendMessage(chatID, "Access mode: "+h.services.Access.Mode())
+		return
+	}
+
+	if !config.ValidAccessMode(mode) {
+		_, _ = h.api.SendMessage(chatID, "Usage: /access open|allowlist|group|invite")
+		return
+	}
+
+	err := h.services.Access.SetMode(mode)
+	switch {
+	case errors.Is(err, accesssvc.ErrGroupNotConfigured):
+		_, _ = h.api.SendMessage(chatID, "Group mode needs a group: set access_group_id in the config and restart the bot")
+		return
+	case err != nil:
+		response.InternalError(h.api, chatID, "AccessHandler.ModeResponse", err)
+		return
+	}
+
+	_, _ = h.api.SendMessage(chatID, "Access mode set to "+mode)
+}
+
+// AllowResponse handles "/allow <user_id>" and "/disallow <user_id>".
+func (h *Handler) AllowResponse(chatID int64, args string, allowed bool) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		_, _ = h.api.SendMessage(chatID, "Usage: /allow <user_id> or /disallow <user_id>")
+		return
+	}
+
+	err = h.services.Access.SetAllowed(userID, allowed)
+	if err != nil {
+		response.InternalError(h.api, chatID, "AccessHandler.AllowResponse", err)
+		return
+	}
+
+	_, _ = h.api.SendMessage(chatID, fmt.Sprintf("User %d allowed status set to %t", userID, allowed))
+}
+
+func (h *Handler) InviteResponse(chatID, adminID int64) {
+	code, err := h.services.Access.CreateInvite(adminID)
+	if err != nil {
+		response.InternalError(h.api, chatID, "AccessHandler.InviteResponse", err)
+		return
+	}
+
+	_, _ = h.api.SendMessage(chatID, fmt.Sprintf("One-time invite code: %s\nUser has to send: /start %s", code, code))
+}
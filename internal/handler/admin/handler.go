@@ -0,0 +1,294 @@
+package admin
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"seventv2tg/internal/config"
+	"seventv2tg/internal/domain"
+	"seventv2tg/internal/handler/response"
+	"seventv2tg/internal/infrastructure/storage"
+	"seventv2tg/internal/service"
+)
+
+const (
+	// Telegram allows about 30 messages per second to different chats.
+	broadcastInterval = time.Second / 20
+
+	maxQueueListed = 30
+
+	backFromMaintenanceMessage = "We're back! Maintenance is over, you can send your emotes again."
+)
+
+type (
+	botApi interface {
+		SendMessage(chatID int64, message string) (tgbotapi.Message, error)
+	}
+
+	Handler struct {
+		cfg      *config.Config
+		api      botApi
+		services *service.Services
+		storages *storage.Storages
+
+		startedAt time.Time
+		// sendTicker is shared by all broadcasts, so that concurrent ones together stay within Telegram limits.
+		sendTicker *time.Ticker
+	}
+)
+
+func New(cfg *config.Config, botAPI botApi, services *service.Services, storages *storage.Storages) *Handler {
+	return &Handler{
+		cfg:        cfg,
+		api:        botAPI,
+		services:   services,
+		storages:   storages,
+		startedAt:  time.Now(),
+		sendTicker: time.NewTicker(broadcastInterval),
+	}
+}
+
+// TrackUser remembers the user for broadcasts and reports whether the user is banned.
+func (h *Handler) TrackUser(from *tgbotapi.User, chatID int64) (banned bool) {
+	user, err := h.storages.Users.Touch(from.ID, chatID, from.UserName)
+	if err != nil {
+		slog.Error("AdminHandler.TrackUser", slog.Int64("userID", from.ID), slog.Any("err", err.Error()))
+		return false
+	}
+
+	return user.Banned
+}
+
+func (h *Handler) StatsResponse(chatID int64) {
+	stats, err := h.storages.Jobs.Stats()
+	if err != nil {
+		response.InternalError(h.api, chatID, "AdminHandler.StatsResponse", err)
+		return
+	}
+
+	users, err := h.storages.Users.List()
+	if err != nil {
+		response.InternalError(h.api, chatID, "AdminHandler.StatsResponse", err)
+		return
+	}
+
+	banned := 0
+	for i := range users {
+		if users[i].Banned {
+			banned++
+		}
+	}
+
+	finished := stats.Done + stats.Failed
+	failureRate := 0.0
+	if finished > 0 {
+		failureRate = float64(stats.Failed) / float64(finished) * 100
+	}
+
+	avgAttempts := 0.0
+	if encodes := h.services.Metrics.Encodes.Load(); encodes > 0 {
+		avgAttempts = float64(h.services.Metrics.EncodeAttempts.Load()) / float64(encodes)
+	}
+
+	message := fmt.Sprintf(
+		"Uptime: %s\n"+
+			"Jobs processed: %d (done %d, failed %d, canceled %d)\n"+
+			"Failure rate: %.1f%%\n"+
+			"Average encode time: %s, attempts: %.1f\n"+
+			"Queue: %d pending, %d running\n"+
+			"Known users: %d, banned: %d\n"+
+			"Panics: updates %d, jobs %d, worker restarts %d",
+		time.Since(h.startedAt).Round(time.Second),
+		finished+stats.Canceled, stats.Done, stats.Failed, stats.Canceled,
+		failureRate,
+		stats.AvgDuration.Round(time.Millisecond*100), avgAttempts,
+		stats.Queued, stats.Running,
+		len(users), banned,
+		h.services.Metrics.UpdatePanics.Load(),
+		h.services.Metrics.JobPanics.Load(),
+		h.services.Metrics.WorkerRestarts.Load(),
+	)
+
+	_, _ = h.api.SendMessage(chatID, message)
+}
+
+func (h *Handler) QueueResponse(chatID int64) {
+	jobs, err := h.storages.Jobs.ListByStatus(domain.JobStatusRunning, domain.JobStatusQueued)
+	if err != nil {
+		response.InternalError(h.api, chatID, "AdminHandler.QueueResponse", err)
+		return
+	}
+
+	if len(jobs) == 0 {
+		_, _ = h.api.SendMessage(chatID, "Queue is empty")
+		return
+	}
+
+	// running jobs first, the rest in order of creation
+	slices.SortStableFunc(jobs, func(a, b domain.Job) int {
+		if a.Status == b.Status {
+			return 0
+		}
+		if a.Status == domain.JobStatusRunning {
+			return -1
+		}
+
+		return 1
+	})
+
+	sb := strings.Builder{}
+	sb.WriteString(fmt.Sprintf("Jobs in queue: %d\n", len(jobs)))
+
+	for _, job := range jobs[:min(len(jobs), maxQueueListed)] {
+		sb.WriteString(fmt.Sprintf(
+			"#%d %s user %d, emotes: %d, age: %s\n",
+			job.ID,
+			job.Status,
+			job.UserID,
+			len(job.EmoteIDs),
+			time.Since(job.CreatedAt).Round(time.Second),
+		))
+	}
+
+	if len(jobs) > maxQueueListed {
+		sb.WriteString(fmt.Sprintf("...and %d more", len(jobs)-maxQueueListed))
+	}
+
+	_, _ = h.api.SendMessage(chatID, sb.String())
+}
+
+func (h *Handler) BanResponse(chatID int64, args string, banned bool) {
+	userID, err := strconv.ParseInt(strings.TrimSpace(args), 10, 64)
+	if err != nil {
+		_, _ = h.api.SendMessage(chatID, "Usage: /ban <user_id> or /unban <user_id>")
+		return
+	}
+
+	if banned && slices.Contains(h.cfg.AdminIDs, userID) {
+		_, _ = h.api.SendMessage(chatID, "Admins can not be banned")
+		return
+	}
+
+	err = h.storages.Users.SetBanned(userID, banned)
+	if err != nil {
+		response.InternalError(h.api, chatID, "AdminHandler.BanResponse", err)
+		return
+	}
+
+	_, _ = h.api.SendMessage(chatID, fmt.Sprintf("User %d banned status set to %t", userID, banned))
+}
+
+// Broadcast sends the text to all known users who are not banned. Sending is done in background.
+func (h *Handler) Broadcast(chatID int64, text string) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		_, _ = h.api.SendMessage(chatID, "Usage: /broadcast <text>")
+		return
+	}
+
+	users, err := h.storages.Users.List()
+	if err != nil {
+		response.InternalError(h.api, chatID, "AdminHandler.Broadcast", err)
+		return
+	}
+
+	var chatIDs []int64
+	for i := range users {
+		if !users[i].Banned && users[i].ChatID != 0 {
+			chatIDs = append(chatIDs, users[i].ChatID)
+		}
+	}
+
+	_, _ = h.api.SendMessage(chatID, fmt.Sprintf("Broadcasting to %d users...", len(chatIDs)))
+
+	go func() {
+		failed := h.sendThrottled(chatIDs, text)
+
+		_, _ = h.api.SendMessage(
+			chatID,
+			fmt.Sprintf("Broadcast finished: sent %d, failed %d", len(chatIDs)-failed, failed),
+		)
+	}()
+}
+
+// MaintenanceResponse handles "/maintenance on <reason> [eta]" and "/maintenance off".
+// Without arguments current status is shown.
+func (h *Handler) MaintenanceResponse(chatID int64, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		_, _ = h.api.SendMessage(chatID, formatMaintenance(h.services.Maintenance.Status()))
+		return
+	}
+
+	switch fields[0] {
+	case "on":
+		var eta time.Duration
+
+		fields = fields[1:]
+		if len(fields) > 0 {
+			if d, err := time.ParseDuration(fields[len(fields)-1]); err == nil {
+				eta = d
+				fields = fields[:len(fields)-1]
+			}
+		}
+
+		status, err := h.services.Maintenance.Enable(strings.Join(fields, " "), eta)
+		if err != nil {
+			response.InternalError(h.api, chatID, "AdminHandler.MaintenanceResponse", err)
+			return
+		}
+
+		_, _ = h.api.SendMessage(chatID, formatMaintenance(status))
+	case "off":
+		turnedAway, err := h.services.Maintenance.Disable()
+		if err != nil {
+			response.InternalError(h.api, chatID, "AdminHandler.MaintenanceResponse", err)
+			return
+		}
+
+		_, _ = h.api.SendMessage(
+			chatID,
+			fmt.Sprintf("Maintenance is off, notifying %d users", len(turnedAway)),
+		)
+
+		go h.sendThrottled(turnedAway, backFromMaintenanceMessage)
+	default:
+		_, _ = h.api.SendMessage(chatID, "Usage: /maintenance on <reason> [eta, e.g. 30m] or /maintenance off")
+	}
+}
+
+func formatMaintenance(status domain.Maintenance) string {
+	if !status.Enabled {
+		return "Maintenance is off"
+	}
+
+	message := fmt.Sprintf("Maintenance is on since %s", status.Since.Format(time.DateTime))
+	if status.Reason != "" {
+		message += "\nReason: " + status.Reason
+	}
+	if !status.ETA.IsZero() {
+		message += "\nETA: " + status.ETA.Format(time.DateTime)
+	}
+
+	return message + fmt.Sprintf("\nUsers turned away: %d", len(status.TurnedAway))
+}
+
+// sendThrottled sends the text to all chats respecting Telegram limits and returns number of failures.
+func (h *Handler) sendThrottled(chatIDs []int64, text string) (failed int) {
+	for _, chatID := range chatIDs {
+		<-h.sendTicker.C
+
+		_, err := h.api.SendMessage(chatID, text)
+		if err != nil {
+			failed++
+		}
+	}
+
+	return failed
+}
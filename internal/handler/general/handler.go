@@ -3,6 +3,9 @@ package general
 import (
 	"fmt"
 	"seventv2tg/internal/config"
+	"seventv2tg/internal/domain"
+	"strings"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
@@ -29,14 +32,30 @@ func (h *Handler) StartResponse(chatID int64) {
 	message := "Welcome to 7tv2tg bot!\n" +
 		"Pick any emote fom https://7tv.app/emotes?a=1 and send me its page link. " +
 		"You can send up to 3 links if you want to overlay emotes.\n" +
+		"To place an overlay, add options after its link, e.g. anchor=top scale=0.5 x=10% y=-20 z=1.\n" +
+		"Add layout=horizontal, layout=vertical or layout=grid to put emotes next to each other.\n" +
+		"Modifiers before a link transform the emote: h! v! flip, r! r180! l! rotate, rev! reverse, " +
+		"pp! ping-pong, x2! speed, hue90! hue shift, g! grayscale.\n" +
+		"Add a caption with text=\"GG EZ\" and textpos=bottom.\n" +
 		"Remember, Telegram restricts animated stickers to 3 seconds max, " +
-		"so longer emotes will be cut."
+		"so longer emotes will be cut. Use /fit to speed them up or pick the best part instead.\n" +
+		"Use /format or format=gif to get a custom emoji, GIF, MP4, APNG or WebP instead of a sticker.\n" +
+		"Send /cancel to abort emotes you have in processing."
 
 	_, _ = h.api.SendMessage(chatID, message)
 }
 
-func (h *Handler) MaintenanceResponse(chatID int64, maintenanceStatus bool) {
-	message := fmt.Sprintf("Maintenance status switched to %t", maintenanceStatus)
+func (h *Handler) MaintenanceNotice(chatID int64, status domain.Maintenance) {
+	message := "Bot is currently in maintenance"
+	if status.Reason != "" {
+		message += ": " + status.Reason
+	}
+
+	if eta := time.Until(status.ETA); eta > 0 {
+		message += fmt.Sprintf(".\nExpected to be back in ~%s", strings.TrimSuffix(eta.Round(time.Minute).String(), "0s"))
+	}
+
+	message += ".\nWe will notify you when it's over."
 
 	_, _ = h.api.SendMessage(chatID, message)
 }
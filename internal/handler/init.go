@@ -2,8 +2,11 @@ package handler
 
 import (
 	"seventv2tg/internal/config"
+	"seventv2tg/internal/handler/access"
+	"seventv2tg/internal/handler/admin"
 	"seventv2tg/internal/handler/general"
 	"seventv2tg/internal/handler/media"
+	"seventv2tg/internal/infrastructure/storage"
 	"seventv2tg/internal/infrastructure/webapi"
 	"seventv2tg/internal/service"
 )
@@ -11,15 +14,26 @@ import (
 type Handlers struct {
 	General *general.Handler
 	Media   *media.Handler
+	Admin   *admin.Handler
+	Access  *access.Handler
 }
 
-func New(cfg *config.Config, apis *webapi.WebAPIs, services *service.Services) *Handlers {
+func New(
+	cfg *config.Config,
+	apis *webapi.WebAPIs,
+	services *service.Services,
+	storages *storage.Storages,
+) *Handlers {
 	generalH := general.New(cfg, apis.TgBot)
-	mediaH := media.New(cfg, apis, services)
+	mediaH := media.New(cfg, apis, services, storages)
+	adminH := admin.New(cfg, apis.TgBot, services, storages)
+	accessH := access.New(cfg, apis.TgBot, services)
 
 	handlers := &Handlers{
 		General: generalH,
 		Media:   mediaH,
+		Admin:   adminH,
+		Access:  accessH,
 	}
 
 	return handlers
@@ -0,0 +1,71 @@
+package media
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/infrastructure/webapi/seventv"
+	mediasvc "seventv2tg/internal/service/media"
+	"seventv2tg/internal/service/media/webp"
+)
+
+// userErrors maps known failures to explanations the user can act upon.
+var userErrors = []struct {
+	err     error
+	message string
+}{
+	{seventv.ErrEmoteNotFound, "Emote not found on 7TV, please check the link"},
+	{seventv.ErrFileTooLarge, "Emote file is too large to process"},
+	{seventv.ErrUnsupportedFormat, "7TV returned emote in unsupported format"},
+	{seventv.ErrUnavailable, "7TV is not responding right now, please try again later"},
+	{webp.ErrInvalidFormat, "Emote file is damaged or not a valid WebP, try another emote"},
+	{webp.ErrNoFrames, "Emote file has no frames to convert, try another emote"},
+	{webp.ErrTooLarge, "Emote is too large or has too many frames to convert, try another emote"},
+	{
+		mediasvc.ErrQualityLimitExceeded,
+		"Emote is too detailed to fit into the size limit of the format even at reduced quality. " +
+			"Try another emote, less overlay layers or another /format",
+	},
+}
+
+func explainError(err error) (message string, ok bool) {
+	for i := range userErrors {
+		if errors.Is(err, userErrors[i].err) {
+			return userErrors[i].message, true
+		}
+	}
+
+	return "", false
+}
+
+// reportError sends the user an explanation of the failure. Internal failures are reported
+// to admins with an error ID the user can quote.
+func (h *Handler) reportError(chatID int64, op string, err error, attrs ...any) {
+	message, ok := explainError(err)
+	if ok {
+		_, _ = h.apis.TgBot.SendMessage(chatID, message)
+		slog.Warn(op, append(attrs, slog.Int64("chatID", chatID), slog.Any("err", err.Error()))...)
+
+		return
+	}
+
+	errorID := uuid.NewString()[:8]
+
+	_, _ = h.apis.TgBot.SendMessage(
+		chatID,
+		fmt.Sprintf("Internal error while processing emote. Error ID: %s", errorID),
+	)
+
+	for _, adminID := range h.cfg.AdminIDs {
+		_, _ = h.apis.TgBot.SendMessage(
+			adminID,
+			fmt.Sprintf("Internal error %s in chat %d (%s): %s", errorID, chatID, op, err.Error()),
+		)
+	}
+
+	attrs = append(attrs, slog.String("errorID", errorID), slog.Int64("chatID", chatID), slog.Any("err", err.Error()))
+	slog.Error(op, attrs...)
+}
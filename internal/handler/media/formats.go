@@ -0,0 +1,70 @@
+package media
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"seventv2tg/internal/domain"
+	mediasvc "seventv2tg/internal/service/media"
+)
+
+// defaultOutputFormat is used when neither the message nor the user's settings choose a format.
+const defaultOutputFormat domain.OutputFormat = "webm"
+
+// outputFormat is what the user picks with /format or format=: how the emote is encoded and sent.
+type outputFormat struct {
+	profile mediasvc.Profile
+	// attachment wraps the result file into the message it is sent with.
+	attachment func(chatID int64, file tgbotapi.RequestFileData, replyToMessageID int, caption string) tgbotapi.Chattable
+}
+
+var outputFormats = map[domain.OutputFormat]outputFormat{
+	defaultOutputFormat: {mediasvc.StickerProfile, documentAttachment(true)},
+	"emoji":             {mediasvc.EmojiProfile, documentAttachment(false)},
+	"gif":               {mediasvc.GIFProfile, documentAttachment(false)},
+	"mp4":               {mediasvc.AnimationProfile, animationAttachment},
+	"apng":              {mediasvc.APNGProfile, documentAttachment(false)},
+	"webp":              {mediasvc.WebPProfile, documentAttachment(false)},
+}
+
+// outputFormatFor returns the format with the given name, the default one for an empty or unknown name.
+func outputFormatFor(name domain.OutputFormat) outputFormat {
+	format, ok := outputFormats[name]
+	if !ok {
+		return outputFormats[defaultOutputFormat]
+	}
+
+	return format
+}
+
+func outputFormatNames() []domain.OutputFormat {
+	return slices.Sorted(maps.Keys(outputFormats))
+}
+
+func joinOutputFormats() string {
+	return strings.Join(stringValues(outputFormatNames()), ", ")
+}
+
+// documentAttachment sends the result as a file. Without content type detection Telegram keeps GIF and WebP
+// as files instead of turning them into an mp4 animation and a sticker.
+func documentAttachment(detectContentType bool) func(int64, tgbotapi.RequestFileData, int, string) tgbotapi.Chattable {
+	return func(chatID int64, file tgbotapi.RequestFileData, replyToMessageID int, caption string) tgbotapi.Chattable {
+		document := tgbotapi.NewDocument(chatID, file)
+		document.ReplyToMessageID = replyToMessageID
+		document.Caption = caption
+		document.DisableContentTypeDetection = !detectContentType
+
+		return document
+	}
+}
+
+func animationAttachment(chatID int64, file tgbotapi.RequestFileData, replyToMessageID int, caption string) tgbotapi.Chattable {
+	animation := tgbotapi.NewAnimation(chatID, file)
+	animation.ReplyToMessageID = replyToMessageID
+	animation.Caption = caption
+
+	return animation
+}
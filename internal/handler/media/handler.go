@@ -2,115 +2,476 @@ package media
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/url"
 	"os"
-	"strconv"
+	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
 	"seventv2tg/internal/config"
 	"seventv2tg/internal/domain"
+	"seventv2tg/internal/infrastructure/storage"
 	"seventv2tg/internal/infrastructure/webapi"
 	"seventv2tg/internal/service"
+	"seventv2tg/internal/service/admission"
+	"seventv2tg/internal/service/limiter"
+	mediasvc "seventv2tg/internal/service/media"
 )
 
 const emoteIdLength = 26
 const maxOverlayedEmotes = 3
 
-type Handler struct {
-	cfg      *config.Config
-	apis     *webapi.WebAPIs
-	services *service.Services
+// Jobs older than this are not resumed after restart, the user is asked to resend them instead.
+const maxResumableJobAge = time.Hour
 
-	reqQueue chan domain.UserRequest
+const workerRestartDelay = time.Second
 
-	activityCache *cache.Cache
-}
+const jobPruneInterval = 24 * time.Hour
+
+type (
+	runningJob struct {
+		userID int64
+		cancel context.CancelFunc
+	}
+
+	Handler struct {
+		cfg      *config.Config
+		apis     *webapi.WebAPIs
+		services *service.Services
+		storages *storage.Storages
+
+		running map[uint64]runningJob
+		mu      sync.Mutex
+	}
+)
 
-func New(cfg *config.Config, apis *webapi.WebAPIs, services *service.Services) *Handler {
+func New(cfg *config.Config, apis *webapi.WebAPIs, services *service.Services, storages *storage.Storages) *Handler {
 	h := &Handler{
-		cfg:           cfg,
-		apis:          apis,
-		services:      services,
-		reqQueue:      make(chan domain.UserRequest, 50),
-		activityCache: cache.New(cache.NoExpiration, cache.NoExpiration),
+		cfg:      cfg,
+		apis:     apis,
+		services: services,
+		storages: storages,
+		running:  make(map[uint64]runningJob),
+	}
+
+	for i := range cfg.MediaWorkersCount {
+		go h.superviseWorker(i)
 	}
 
-	for range cfg.MediaWorkersCount {
-		go h.mediaWorker()
+	if cfg.JobHistoryDays > 0 {
+		go h.pruneJobs()
 	}
 
 	return h
 }
 
 func (h *Handler) CreateVideoFromEmote(ctx context.Context, message *tgbotapi.Message) {
-	if _, ok := h.activityCache.Get(strconv.FormatInt(message.Chat.ID, 10)); ok {
-		_, _ = h.apis.TgBot.SendMessage(message.Chat.ID, "You have another emote being processed, please wait")
-		return
+	req, ok := RequestFrom(ctx)
+	if !ok {
+		req.EmoteIDs, req.Options, req.Err = h.ParseRequest(message.Text)
 	}
 
-	userInput := strings.Fields(message.Text)
-	userInput = userInput[:min(len(userInput), maxOverlayedEmotes)]
-
-	var emoteIDs []string
+	if req.Err != nil {
+		h.invalidRequestResponse(message.Chat.ID, req.Err)
+		return
+	}
 
-	for i := range userInput {
-		emoteID, err := h.validateUserInput(userInput[i])
-		if err != nil {
-			_, _ = h.apis.TgBot.SendMessage(message.Chat.ID, "Invalid emote URL")
-			return
-		}
+	emoteIDs, opts := req.EmoteIDs, req.Options
 
-		emoteIDs = append(emoteIDs, emoteID)
+	prefs, err := h.storages.Prefs.Get(message.From.ID)
+	if err != nil {
+		slog.Error("MediaHandler.CreateVideoFromEmote", slog.Int64("userID", message.From.ID), slog.Any("err", err.Error()))
 	}
+	applyPreferences(&opts, prefs)
 
-	h.activityCache.Set(strconv.FormatInt(message.Chat.ID, 10), struct{}{}, cache.NoExpiration)
-	defer h.activityCache.Delete(strconv.FormatInt(message.Chat.ID, 10))
-
-	req := domain.UserRequest{
+	job := &domain.Job{
 		ChatID:           message.Chat.ID,
+		UserID:           message.From.ID,
 		ReplyToMessageID: message.MessageID,
 		EmoteIDs:         emoteIDs,
-		ErrChan:          make(chan error),
+		Options:          opts,
 	}
-	h.reqQueue <- req
 
-	msg, err := h.apis.TgBot.SendMessage(req.ChatID, "Emote added to processing queue")
+	err = h.storages.Jobs.Create(job)
+	if err != nil {
+		h.reportError(message.Chat.ID, "MediaHandler.CreateVideoFromEmote", err, slog.Any("emoteIDs", emoteIDs))
+		return
+	}
+
+	h.processJob(job, "Emote added to processing queue")
+}
+
+// ResumeJobs puts jobs left unfinished by the previous run back to the queue.
+func (h *Handler) ResumeJobs() {
+	jobs, err := h.storages.Jobs.ListByStatus(domain.JobStatusQueued, domain.JobStatusRunning)
+	if err != nil {
+		slog.Error("MediaHandler.ResumeJobs", slog.Any("err", err.Error()))
+		return
+	}
+
+	for i := range jobs {
+		job := &jobs[i]
+
+		if time.Since(job.CreatedAt) > maxResumableJobAge {
+			h.setJobStatus(job.ID, domain.JobStatusFailed, errors.New("expired during restart"))
+			_, _ = h.apis.TgBot.SendMessage(
+				job.ChatID,
+				"Bot was restarted and your request has expired, please send it again",
+			)
+
+			continue
+		}
+
+		if job.Status == domain.JobStatusRunning {
+			h.setJobStatus(job.ID, domain.JobStatusQueued, nil)
+		}
+
+		// в очередь кладем синхронно, чтобы задачи сохранили порядок создания, ждем результат в фоне
+		req, position := h.enqueue(job)
+		go h.awaitJob(req, position, "Bot was restarted, your emote is back in the processing queue")
+	}
+
+	if len(jobs) > 0 {
+		slog.Info("Unfinished jobs restored", slog.Int("count", len(jobs)))
+	}
+}
+
+func (h *Handler) processJob(job *domain.Job, queuedMessage string) {
+	req, position := h.enqueue(job)
+	h.awaitJob(req, position, queuedMessage)
+}
+
+// enqueue pushes the job to the scheduler and returns its request with the queue position.
+func (h *Handler) enqueue(job *domain.Job) (domain.UserRequest, int) {
+	req := job.Request()
+
+	return req, h.services.Scheduler.Push(req)
+}
+
+// awaitJob tells the user the queue position and reports the job result when it is done.
+func (h *Handler) awaitJob(req domain.UserRequest, position int, queuedMessage string) {
+	queuedMessage = fmt.Sprintf(
+		"%s, position: %d (~%s)",
+		queuedMessage,
+		position,
+		formatWait(h.services.Admission.EstimateWait(position)),
+	)
+
+	msg, err := h.apis.TgBot.SendMessage(req.ChatID, queuedMessage)
 	if err == nil {
 		defer h.apis.TgBot.DeleteMessage(req.ChatID, msg.MessageID)
 	}
 
 	err = <-req.ErrChan
-	if err != nil {
-		_, _ = h.apis.TgBot.SendMessage(req.ChatID, "Unknown error while processing emote")
-
-		slog.Error(
-			"MediaHandler.CreateVideoFromEmote",
-			slog.Int64("chatID", req.ChatID),
+	if err != nil && !errors.Is(err, context.Canceled) {
+		h.reportError(
+			req.ChatID,
+			"MediaHandler.processJob",
+			err,
+			slog.Uint64("jobID", req.JobID),
 			slog.Any("emoteIDs", req.EmoteIDs),
-			slog.Any("err", err.Error()),
 		)
 	}
 }
 
-func (h *Handler) mediaWorker() {
-	var err error
+// Cancel drops queued jobs of the user and aborts the running ones.
+func (h *Handler) Cancel(message *tgbotapi.Message) {
+	userID := message.From.ID
 
-	for req := range h.reqQueue {
-		if len(req.EmoteIDs) > 1 {
-			err = h.processOverlayedEmote(req.ChatID, req.ReplyToMessageID, req.EmoteIDs)
-		} else {
-			err = h.processSingleEmote(req.ChatID, req.ReplyToMessageID, req.EmoteIDs[0])
+	removed := h.services.Scheduler.Remove(userID)
+	for _, req := range removed {
+		h.setJobStatus(req.JobID, domain.JobStatusCanceled, nil)
+		req.ErrChan <- context.Canceled
+		close(req.ErrChan)
+	}
+
+	canceled := len(removed)
+
+	h.mu.Lock()
+	for _, job := range h.running {
+		if job.userID == userID {
+			job.cancel()
+			canceled++
 		}
+	}
+	h.mu.Unlock()
+
+	if canceled == 0 {
+		_, _ = h.apis.TgBot.SendMessage(message.Chat.ID, "You have no emotes in processing")
+		return
+	}
+
+	_, _ = h.apis.TgBot.SendMessage(message.Chat.ID, fmt.Sprintf("Canceled emotes in processing: %d", canceled))
+}
+
+func (h *Handler) invalidRequestResponse(chatID int64, err error) {
+	var modErr *ModifierError
+	if errors.As(err, &modErr) {
+		_, _ = h.apis.TgBot.SendMessage(chatID, fmt.Sprintf(
+			"Unknown modifier %q. Available: h! v! r! r180! l! rev! pp! g! x2! hue90!",
+			modErr.Modifier,
+		))
+
+		return
+	}
+
+	var optErr *OptionError
+	if !errors.As(err, &optErr) {
+		_, _ = h.apis.TgBot.SendMessage(chatID, "Invalid emote URL")
+		return
+	}
+
+	var message string
+
+	switch {
+	case optErr.NoLayer:
+		message = fmt.Sprintf("Option %q must follow the emote link it applies to", optErr.Option)
+	case optErr.Option == fitOption:
+		message = fmt.Sprintf("Unknown fit strategy %q, available: %s", optErr.Value, joinFitStrategies())
+	case optErr.Option == formatOption:
+		message = fmt.Sprintf("Unknown format %q, available: %s", optErr.Value, joinOutputFormats())
+	case optErr.Option == captionOption:
+		message = fmt.Sprintf("Caption must be 1 to %d characters long, use quotes for several words", maxCaptionLength)
+	case optErr.Option == captionPositionOption:
+		message = "Caption position must be top or bottom"
+	case optErr.Option == layoutOption:
+		message = fmt.Sprintf("Unknown layout %q, available: stack, horizontal, vertical, grid", optErr.Value)
+	case optErr.Option == anchorOption:
+		message = fmt.Sprintf(
+			"Unknown anchor %q, available: center, top, bottom, left, right, top-left, top-right, bottom-left, bottom-right",
+			optErr.Value,
+		)
+	case isLayerOption(optErr.Option):
+		message = fmt.Sprintf(
+			"Invalid value %q of %q. Use scale=0.5, x=10 or x=-20%%, y=15%%, z=1",
+			optErr.Value,
+			optErr.Option,
+		)
+	default:
+		message = fmt.Sprintf("Unknown option %q", optErr.Option)
+	}
+
+	_, _ = h.apis.TgBot.SendMessage(chatID, message)
+}
+
+// RejectResponse explains to the user why the request was not accepted.
+func (h *Handler) RejectResponse(chatID int64, err error) {
+	var message string
+
+	var exceeded *limiter.ExceededError
+	var busy *admission.BusyError
+
+	switch {
+	case errors.As(err, &exceeded):
+		message = fmt.Sprintf(
+			"You have reached the limit of %s. Try again in %s",
+			exceeded.Limit,
+			formatWait(exceeded.RetryIn),
+		)
+	case errors.As(err, &busy):
+		message = fmt.Sprintf("Bot is busy right now, try again in ~%s", formatWait(busy.RetryIn))
+	default:
+		h.reportError(chatID, "MediaHandler.RejectResponse", err)
+		return
+	}
+
+	_, _ = h.apis.TgBot.SendMessage(chatID, message)
+}
+
+func formatWait(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
+
+// pruneJobs keeps job history at cfg.JobHistoryDays, so that stats and resume do not read it whole.
+func (h *Handler) pruneJobs() {
+	history := time.Duration(h.cfg.JobHistoryDays) * 24 * time.Hour
+
+	for {
+		pruned, err := h.storages.Jobs.Prune(time.Now().Add(-history))
+		if err != nil {
+			slog.Error("MediaHandler.pruneJobs", slog.Any("err", err.Error()))
+		} else if pruned > 0 {
+			slog.Info("Old jobs pruned", slog.Int("count", pruned))
+		}
+
+		time.Sleep(jobPruneInterval)
+	}
+}
+
+// superviseWorker restarts media worker if it dies from a panic outside of job processing.
+func (h *Handler) superviseWorker(workerID int) {
+	for {
+		func() {
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+
+				h.services.Metrics.WorkerRestarts.Add(1)
+
+				slog.Error(
+					"Media worker panicked, restarting",
+					slog.Int("workerID", workerID),
+					slog.Any("panic", rec),
+					slog.String("stack", string(debug.Stack())),
+				)
+			}()
+
+			h.mediaWorker()
+		}()
+
+		time.Sleep(workerRestartDelay)
+	}
+}
+
+func (h *Handler) mediaWorker() {
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		// задача становится running вместе с извлечением из очереди, чтобы /cancel ее не пропустил
+		req := h.services.Scheduler.Pop(func(req domain.UserRequest) {
+			h.setRunning(req, cancel)
+		})
+
+		h.handleRequest(ctx, cancel, req)
+	}
+}
+
+// handleRequest runs popped request to the end. Whatever happens, even a panic outside of job processing,
+// the request is finished: its job gets the final status and ErrChan is closed, so processJob never hangs.
+func (h *Handler) handleRequest(ctx context.Context, cancel context.CancelFunc, req domain.UserRequest) {
+	var finished bool
+
+	finish := func(status domain.JobStatus, err error) {
+		finished = true
+
 		if err != nil {
 			req.ErrChan <- err
 		}
 		close(req.ErrChan)
+
+		// отмена не ошибка задачи
+		if status == domain.JobStatusCanceled {
+			err = nil
+		}
+
+		h.setJobStatus(req.JobID, status, err)
+	}
+
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+
+		h.services.Metrics.JobPanics.Add(1)
+
+		slog.Error(
+			"Panic while handling job",
+			slog.Uint64("jobID", req.JobID),
+			slog.Int64("userID", req.UserID),
+			slog.Any("panic", rec),
+			slog.String("stack", string(debug.Stack())),
+		)
+
+		h.unsetRunning(req)
+
+		if !finished {
+			finish(domain.JobStatusFailed, errors.Errorf("panic: %v", rec))
+		}
+	}()
+
+	defer cancel()
+
+	h.setJobStatus(req.JobID, domain.JobStatusRunning, nil)
+
+	startedAt := time.Now()
+
+	err := h.processRequest(ctx, req)
+
+	h.unsetRunning(req)
+
+	// Убитый по отмене ffmpeg возвращает свою ошибку, поэтому смотрим на сам контекст.
+	if err != nil && ctx.Err() != nil {
+		finish(domain.JobStatusCanceled, errors.Wrap(context.Canceled, err.Error()))
+		return
+	}
+
+	h.services.Admission.Observe(time.Since(startedAt))
+
+	if err != nil {
+		finish(domain.JobStatusFailed, err)
+		return
+	}
+
+	finish(domain.JobStatusDone, nil)
+}
+
+// processRequest converts panic during job processing into job error so the worker keeps running.
+func (h *Handler) processRequest(ctx context.Context, req domain.UserRequest) (err error) {
+	defer func() {
+		rec := recover()
+		if rec == nil {
+			return
+		}
+
+		h.services.Metrics.JobPanics.Add(1)
+
+		slog.Error(
+			"Panic while processing job",
+			slog.Uint64("jobID", req.JobID),
+			slog.Int64("userID", req.UserID),
+			slog.Int64("chatID", req.ChatID),
+			slog.Any("emoteIDs", req.EmoteIDs),
+			slog.Any("panic", rec),
+			slog.String("stack", string(debug.Stack())),
+		)
+
+		err = errors.Errorf("panic: %v", rec)
+	}()
+
+	if len(req.EmoteIDs) > 1 {
+		return h.processOverlayedEmote(ctx, req.ChatID, req.ReplyToMessageID, req.EmoteIDs, req.Options)
+	}
+
+	return h.processSingleEmote(ctx, req.ChatID, req.ReplyToMessageID, req.EmoteIDs[0], req.Options)
+}
+
+func (h *Handler) setRunning(req domain.UserRequest, cancel context.CancelFunc) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.running[req.JobID] = runningJob{userID: req.UserID, cancel: cancel}
+}
+
+func (h *Handler) unsetRunning(req domain.UserRequest) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.running, req.JobID)
+}
+
+func (h *Handler) setJobStatus(jobID uint64, status domain.JobStatus, jobErr error) {
+	err := h.storages.Jobs.SetStatus(jobID, status, jobErr)
+	if err != nil {
+		slog.Error(
+			"MediaHandler.setJobStatus",
+			slog.Uint64("jobID", jobID),
+			slog.String("status", string(status)),
+			slog.Any("err", err.Error()),
+		)
 	}
 }
 
@@ -141,7 +502,7 @@ func (h *Handler) validateUserInput(inp string) (emoteID string, err error) {
 	return parts[1], nil
 }
 
-func (h *Handler) processSingleEmote(chatID int64, replyToMessageID int, emoteID string) error {
+func (h *Handler) processSingleEmote(ctx context.Context, chatID int64, replyToMessageID int, emoteID string, opts domain.ConvertOptions) error {
 	const errMsg = "processSingleEmote"
 
 	var err error
@@ -152,67 +513,92 @@ func (h *Handler) processSingleEmote(chatID int64, replyToMessageID int, emoteID
 		_ = os.RemoveAll(paths.Webm)
 	}()
 
-	paths.Webp, err = h.apis.SevenTV.DownloadWebp(emoteID)
-	if err != nil {
-		return errors.Wrap(err, errMsg)
-	}
-
-	paths.Webm, err = h.services.Media.ConvertToVideo(paths.Webp)
+	paths.Webp, err = h.apis.SevenTV.DownloadWebp(ctx, emoteID)
 	if err != nil {
 		return errors.Wrap(err, errMsg)
 	}
 
-	attachment := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(paths.Webm))
-	attachment.ReplyToMessageID = replyToMessageID
-
-	err = h.apis.TgBot.SendAttachment(attachment)
+	res, err := h.services.Media.ConvertToVideo(ctx, paths.Webp, outputFormatFor(opts.Format).profile, opts)
+	paths.Webm = res.Path
 	if err != nil {
 		return errors.Wrap(err, errMsg)
 	}
 
-	return nil
+	return errors.Wrap(h.sendResult(chatID, replyToMessageID, opts.Format, res), errMsg)
 }
 
-func (h *Handler) processOverlayedEmote(chatID int64, replyToMessageID int, emoteIDs []string) error {
+func (h *Handler) processOverlayedEmote(ctx context.Context, chatID int64, replyToMessageID int, emoteIDs []string, opts domain.ConvertOptions) error {
 	const errMsg = "processOverlayedEmote"
 
 	var err error
-	var resFilePath string
+	var res mediasvc.Result
 
 	webpPaths := make([]string, len(emoteIDs))
 
 	defer func() {
-		_ = os.RemoveAll(resFilePath)
+		_ = os.RemoveAll(res.Path)
 		for i := range webpPaths {
 			_ = os.RemoveAll(webpPaths[i])
 		}
 	}()
 
-	eg := errgroup.Group{}
+	eg, egCtx := errgroup.WithContext(ctx)
 	for i := range emoteIDs {
-		eg.Go(func() error {
-			webpPaths[i], err = h.apis.SevenTV.DownloadWebp(emoteIDs[i])
+		eg.Go(func() (err error) {
+			webpPaths[i], err = h.apis.SevenTV.DownloadWebp(egCtx, emoteIDs[i])
 
 			return err
 		})
 	}
 
+	var zeroWidth []bool
+	if opts.Layout == "" || opts.Layout == domain.LayoutStack {
+		eg.Go(func() error {
+			zeroWidth = h.zeroWidthFlags(egCtx, emoteIDs)
+			return nil
+		})
+	}
+
 	if err = eg.Wait(); err != nil {
 		return errors.Wrap(err, errMsg)
 	}
 
-	resFilePath, err = h.services.Media.OverlayVideos(webpPaths)
+	layerPaths, opts := arrangeZeroWidth(webpPaths, opts, zeroWidth)
+
+	res, err = h.services.Media.OverlayVideos(ctx, layerPaths, outputFormatFor(opts.Format).profile, opts)
 	if err != nil {
 		return errors.Wrap(err, errMsg)
 	}
 
-	attachment := tgbotapi.NewDocument(chatID, tgbotapi.FilePath(resFilePath))
-	attachment.ReplyToMessageID = replyToMessageID
+	return errors.Wrap(h.sendResult(chatID, replyToMessageID, opts.Format, res), errMsg)
+}
 
-	err = h.apis.TgBot.SendAttachment(attachment)
-	if err != nil {
-		return errors.Wrap(err, errMsg)
+func (h *Handler) sendResult(chatID int64, replyToMessageID int, formatName domain.OutputFormat, res mediasvc.Result) error {
+	h.services.Metrics.Encodes.Add(1)
+	h.services.Metrics.EncodeAttempts.Add(int64(res.Stats.Attempts))
+
+	slog.Debug(
+		"Emote converted",
+		slog.Int64("chatID", chatID),
+		slog.Int("attempts", res.Stats.Attempts),
+		slog.Int("bitrate", res.Stats.Bitrate),
+		slog.Int64("size", res.Stats.Size),
+		slog.Any("compromises", res.Stats.Compromises),
+	)
+
+	format := outputFormatFor(formatName)
+
+	var caption string
+	if len(res.Stats.Compromises) > 0 {
+		caption = fmt.Sprintf(
+			"To fit into %s %s limit: %s",
+			formatSize(format.profile.MaxBytes),
+			format.profile.Name,
+			strings.Join(res.Stats.Compromises, ", "),
+		)
 	}
 
-	return nil
+	attachment := format.attachment(chatID, tgbotapi.FilePath(res.Path), replyToMessageID, caption)
+
+	return errors.Wrap(h.apis.TgBot.SendAttachment(attachment), "sendResult")
 }
@@ -0,0 +1,342 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/domain"
+)
+
+const (
+	fitOption             = "fit"
+	formatOption          = "format"
+	layoutOption          = "layout"
+	captionOption         = "text"
+	captionPositionOption = "textpos"
+
+	maxCaptionLength = 64
+
+	anchorOption  = "anchor"
+	scaleOption   = "scale"
+	offsetXOption = "x"
+	offsetYOption = "y"
+	zOption       = "z"
+
+	maxLayerScale   = 4
+	maxOffsetPixels = 512
+)
+
+var fitStrategies = []domain.FitStrategy{
+	domain.FitTruncate,
+	domain.FitSpeedUp,
+	domain.FitDropFrames,
+	domain.FitBestWindow,
+}
+
+// OptionError is returned for key=value tokens the bot does not understand.
+type OptionError struct {
+	Option string
+	Value  string
+	// NoLayer is set for layer options sent before any emote link.
+	NoLayer bool
+}
+
+func (e *OptionError) Error() string {
+	if e.NoLayer {
+		return fmt.Sprintf("layer option %q before emote link", e.Option)
+	}
+
+	if e.Value == "" {
+		return fmt.Sprintf("unknown option %q", e.Option)
+	}
+
+	return fmt.Sprintf("invalid value %q of option %q", e.Value, e.Option)
+}
+
+// Request is the result of ParseRequest, parsed once and passed down with the context.
+type Request struct {
+	EmoteIDs []string
+	Options  domain.ConvertOptions
+	Err      error
+}
+
+type requestKey struct{}
+
+// WithRequest returns ctx carrying the parsed request.
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFrom returns the request stored by WithRequest.
+func RequestFrom(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+
+	return req, ok
+}
+
+// ParseRequest extracts up to maxOverlayedEmotes emote IDs and key=value conversion options from the message.
+// Layer options (anchor, scale, x, y, z) apply to the closest emote link before them.
+// Modifiers (h!, r!, x2! and so on) apply to the next emote link, the ones after all links apply to the last one.
+func (h *Handler) ParseRequest(text string) (emoteIDs []string, opts domain.ConvertOptions, err error) {
+	const errMsg = "MediaHandler.ParseRequest"
+
+	tokens := splitTokens(text)
+	if len(tokens) == 0 {
+		return nil, opts, errors.Wrap(errors.New("empty input"), errMsg)
+	}
+
+	// опции слоя после лишних ссылок отбрасываются вместе с ними
+	var layer *domain.LayerOptions
+	var skipLayer bool
+
+	var modifiers []string
+
+	for _, token := range tokens {
+		// ссылки тоже могут содержать "=" в query, у опций ключ без "/"
+		if key, value, ok := strings.Cut(token, "="); ok && !strings.Contains(key, "/") {
+			key = strings.ToLower(key)
+			if key != captionOption {
+				value = strings.ToLower(value)
+			}
+
+			if isLayerOption(key) {
+				if layer == nil && !skipLayer {
+					return nil, opts, errors.Wrap(&OptionError{Option: key, Value: value, NoLayer: true}, errMsg)
+				}
+
+				if !skipLayer {
+					err = parseLayerOption(&layer.Placement, key, value)
+				}
+			} else {
+				err = parseOption(&opts, key, value)
+			}
+
+			if err != nil {
+				return nil, opts, errors.Wrap(err, errMsg)
+			}
+
+			continue
+		}
+
+		if isModifier(token) {
+			modifiers = append(modifiers, strings.ToLower(token))
+			continue
+		}
+
+		emoteID, err := h.validateUserInput(token)
+		if err != nil {
+			return nil, opts, errors.Wrap(err, errMsg)
+		}
+
+		skipLayer = len(emoteIDs) == maxOverlayedEmotes
+		if skipLayer {
+			modifiers = nil
+			continue
+		}
+
+		emoteIDs = append(emoteIDs, emoteID)
+		opts.Layers = append(opts.Layers, domain.LayerOptions{})
+		layer = &opts.Layers[len(opts.Layers)-1]
+
+		err = parseModifiers(&layer.Modifiers, modifiers)
+		if err != nil {
+			return nil, opts, errors.Wrap(err, errMsg)
+		}
+		modifiers = nil
+	}
+
+	if len(emoteIDs) == 0 {
+		return nil, opts, errors.Wrap(errors.New("no emotes"), errMsg)
+	}
+
+	if !skipLayer {
+		err = parseModifiers(&layer.Modifiers, modifiers)
+		if err != nil {
+			return nil, opts, errors.Wrap(err, errMsg)
+		}
+	}
+
+	// без опций слоев не храним пустые
+	if slices.Equal(opts.Layers, make([]domain.LayerOptions, len(opts.Layers))) {
+		opts.Layers = nil
+	}
+
+	return emoteIDs, opts, nil
+}
+
+func parseOption(opts *domain.ConvertOptions, key, value string) error {
+	switch key {
+	case fitOption:
+		fit := domain.FitStrategy(value)
+		if !fit.Valid() {
+			return &OptionError{Option: key, Value: value}
+		}
+		opts.Fit = fit
+	case formatOption:
+		format := domain.OutputFormat(value)
+		if _, ok := outputFormats[format]; !ok {
+			return &OptionError{Option: key, Value: value}
+		}
+		opts.Format = format
+	case layoutOption:
+		layout := domain.Layout(value)
+		if !layout.Valid() {
+			return &OptionError{Option: key, Value: value}
+		}
+		opts.Layout = layout
+	case captionOption:
+		value = strings.TrimSpace(value)
+		if value == "" || utf8.RuneCountInString(value) > maxCaptionLength {
+			return &OptionError{Option: key, Value: value}
+		}
+		opts.Caption.Text = value
+	case captionPositionOption:
+		position := domain.CaptionPosition(value)
+		if position != domain.CaptionTop && position != domain.CaptionBottom {
+			return &OptionError{Option: key, Value: value}
+		}
+		opts.Caption.Position = position
+	default:
+		return &OptionError{Option: key}
+	}
+
+	return nil
+}
+
+// splitTokens splits text by whitespace keeping quoted parts together: text="GG EZ" is a single token
+// without quotes. Typographic quotes some Telegram clients insert are supported too.
+func splitTokens(text string) []string {
+	var tokens []string
+	var current strings.Builder
+	var quoted, inToken bool
+
+	for _, char := range text {
+		switch {
+		case !quoted && isOpeningQuote(char):
+			quoted, inToken = true, true
+		case quoted && isClosingQuote(char):
+			quoted = false
+		case !quoted && unicode.IsSpace(char):
+			if inToken {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				inToken = false
+			}
+		default:
+			current.WriteRune(char)
+			inToken = true
+		}
+	}
+
+	if inToken {
+		tokens = append(tokens, current.String())
+	}
+
+	return tokens
+}
+
+func isOpeningQuote(char rune) bool {
+	return char == '"' || char == '“' || char == '«'
+}
+
+func isClosingQuote(char rune) bool {
+	return char == '"' || char == '”' || char == '»'
+}
+
+func isLayerOption(key string) bool {
+	switch key {
+	case anchorOption, scaleOption, offsetXOption, offsetYOption, zOption:
+		return true
+	}
+
+	return false
+}
+
+func parseLayerOption(layer *domain.Placement, key, value string) error {
+	invalid := &OptionError{Option: key, Value: value}
+
+	switch key {
+	case anchorOption:
+		anchor := domain.Anchor(value)
+		if !anchor.Valid() {
+			return invalid
+		}
+		layer.Anchor = anchor
+	case scaleOption:
+		scale, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		if err != nil || math.IsNaN(scale) || scale <= 0 || scale > maxLayerScale {
+			return invalid
+		}
+		layer.Scale = scale
+	case offsetXOption, offsetYOption:
+		offset, err := parseOffset(value)
+		if err != nil {
+			return invalid
+		}
+
+		if key == offsetXOption {
+			layer.OffsetX = offset
+		} else {
+			layer.OffsetY = offset
+		}
+	case zOption:
+		z, err := strconv.Atoi(value)
+		if err != nil {
+			return invalid
+		}
+		layer.Z = z
+	}
+
+	return nil
+}
+
+// parseOffset reads pixel offset like "-20" or "20px" and percent offset like "10%".
+func parseOffset(value string) (domain.Offset, error) {
+	var offset domain.Offset
+
+	value, offset.Percent = strings.CutSuffix(value, "%")
+	if !offset.Percent {
+		value = strings.TrimSuffix(value, "px")
+	}
+
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return offset, errors.New("invalid offset")
+	}
+
+	limit := float64(maxOffsetPixels)
+	if offset.Percent {
+		limit = 100
+	}
+
+	if math.Abs(v) > limit {
+		return offset, errors.New("offset out of range")
+	}
+
+	offset.Value = v
+
+	return offset, nil
+}
+
+// applyPreferences fills options not set in the message with user defaults.
+func applyPreferences(opts *domain.ConvertOptions, prefs domain.Preferences) {
+	if opts.Fit == "" {
+		opts.Fit = prefs.Fit
+	}
+
+	if opts.Format == "" {
+		opts.Format = prefs.Format
+	}
+}
+
+func joinFitStrategies() string {
+	return strings.Join(stringValues(fitStrategies), ", ")
+}
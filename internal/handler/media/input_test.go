@@ -0,0 +1,99 @@
+package media
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"seventv2tg/internal/domain"
+)
+
+const testEmoteURL = "https://7tv.app/emotes/01F6MZGCNG000255K4X1K0NEP7"
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		wantErr bool
+	}{
+		{"plain link", testEmoteURL, false},
+		{"layer options", testEmoteURL + " " + testEmoteURL + " scale=0.5x x=10% y=-20px z=1", false},
+		{"speed modifier", "x2! " + testEmoteURL, false},
+		{"scale nan", testEmoteURL + " scale=nan", true},
+		{"scale inf", testEmoteURL + " scale=inf", true},
+		{"scale negative inf", testEmoteURL + " scale=-inf", true},
+		{"scale overflow", testEmoteURL + " scale=1e400", true},
+		{"scale zero", testEmoteURL + " scale=0", true},
+		{"offset nan", testEmoteURL + " x=nan", true},
+		{"offset inf", testEmoteURL + " y=inf%", true},
+		{"offset overflow", testEmoteURL + " x=1e400px", true},
+		{"z overflow", testEmoteURL + " z=99999999999999999999", true},
+		{"speed nan", "xnan! " + testEmoteURL, true},
+		{"layer option before link", "scale=2 " + testEmoteURL, true},
+		{"no links", "scale=2", true},
+	}
+
+	h := &Handler{}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, opts, err := h.ParseRequest(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequest(%q) error = %v, wantErr %t", tt.text, err, tt.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// задача сохраняется в JSON, NaN и Inf там недопустимы
+			if _, err := json.Marshal(opts); err != nil {
+				t.Errorf("options of %q are not serializable: %v", tt.text, err)
+			}
+		})
+	}
+}
+
+func TestParseModifiers(t *testing.T) {
+	tests := []struct {
+		name    string
+		tokens  []string
+		want    domain.Modifiers
+		wantErr bool
+	}{
+		{"flips and rotations", []string{"h!", "r!", "r180!"}, domain.Modifiers{FlipH: true, Rotate: 270}, false},
+		{"speed", []string{"x0.5!"}, domain.Modifiers{Speed: 0.5}, false},
+		{"hue wraps", []string{"hue-90!"}, domain.Modifiers{Hue: 270}, false},
+		{"speed nan", []string{"xnan!"}, domain.Modifiers{}, true},
+		{"speed inf", []string{"xinf!"}, domain.Modifiers{}, true},
+		{"speed overflow", []string{"x1e400!"}, domain.Modifiers{}, true},
+		{"speed out of range", []string{"x8!"}, domain.Modifiers{}, true},
+		{"hue nan", []string{"huenan!"}, domain.Modifiers{}, true},
+		{"hue overflow", []string{"hue99999999999999999999!"}, domain.Modifiers{}, true},
+		{"unknown", []string{"zz!"}, domain.Modifiers{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got domain.Modifiers
+
+			err := parseModifiers(&got, tt.tokens)
+			if tt.wantErr {
+				var modErr *ModifierError
+				if !errors.As(err, &modErr) {
+					t.Fatalf("parseModifiers(%q) error = %v, want ModifierError", tt.tokens, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseModifiers(%q) error = %v", tt.tokens, err)
+			}
+
+			if got != tt.want {
+				t.Errorf("parseModifiers(%q) = %+v, want %+v", tt.tokens, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package media
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/domain"
+)
+
+const (
+	modifierSuffix = "!"
+
+	minSpeed = 0.25
+	maxSpeed = 4
+)
+
+// ModifierError is returned for unknown or malformed modifiers.
+type ModifierError struct {
+	Modifier string
+}
+
+func (e *ModifierError) Error() string {
+	return fmt.Sprintf("invalid modifier %q", e.Modifier)
+}
+
+func isModifier(token string) bool {
+	return len(token) > len(modifierSuffix) && strings.HasSuffix(token, modifierSuffix) && !strings.Contains(token, "/")
+}
+
+// parseModifiers applies modifier tokens in order. Rotations add up, other modifiers override previous values.
+func parseModifiers(m *domain.Modifiers, tokens []string) error {
+	for _, token := range tokens {
+		name := strings.TrimSuffix(token, modifierSuffix)
+
+		switch name {
+		case "h":
+			m.FlipH = !m.FlipH
+		case "v":
+			m.FlipV = !m.FlipV
+		case "r", "r90":
+			m.Rotate = (m.Rotate + 90) % 360
+		case "r180":
+			m.Rotate = (m.Rotate + 180) % 360
+		case "l", "r270":
+			m.Rotate = (m.Rotate + 270) % 360
+		case "rev":
+			m.Reverse = !m.Reverse
+		case "pp":
+			m.PingPong = true
+		case "g":
+			m.Grayscale = true
+		default:
+			err := parseValueModifier(m, name)
+			if err != nil {
+				return &ModifierError{Modifier: token}
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseValueModifier reads modifiers with a number: x2 for speed and hue90 for hue shift.
+func parseValueModifier(m *domain.Modifiers, name string) error {
+	if value, ok := strings.CutPrefix(name, "hue"); ok {
+		hue, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+
+		m.Hue = (hue%360 + 360) % 360
+
+		return nil
+	}
+
+	if value, ok := strings.CutPrefix(name, "x"); ok {
+		speed, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(speed) || speed < minSpeed || speed > maxSpeed {
+			return errors.Errorf("invalid speed %q", value)
+		}
+
+		m.Speed = speed
+
+		return nil
+	}
+
+	return errors.Errorf("unknown modifier %q", name)
+}
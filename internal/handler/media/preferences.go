@@ -0,0 +1,141 @@
+package media
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"seventv2tg/internal/domain"
+)
+
+type preference struct {
+	// values are accepted arguments of the command.
+	values []string
+	// describe returns the reply to the command without arguments: current value and usage.
+	describe func(prefs domain.Preferences) string
+	set      func(prefs *domain.Preferences, value string)
+	// unknown and saved prefix replies to an invalid and an accepted argument.
+	unknown, saved string
+}
+
+// FitResponse shows or changes how the user's emotes longer than the format limit are shortened.
+func (h *Handler) FitResponse(message *tgbotapi.Message) {
+	h.preferenceResponse(message, "MediaHandler.FitResponse", preference{
+		values: stringValues(fitStrategies),
+		describe: func(prefs domain.Preferences) string {
+			current := prefs.Fit
+			if current == "" {
+				current = domain.FitTruncate
+			}
+
+			return fmt.Sprintf(
+				"Emotes longer than the format limit (3 seconds for stickers) are shortened with: %s\n"+
+					"Usage: /fit <%s>\n"+
+					"truncate - cut at the limit\n"+
+					"speed - play the whole loop faster\n"+
+					"drop - skip frames evenly\n"+
+					"window - pick the part that loops best\n"+
+					"You can also add fit=<strategy> to a single message.",
+				current,
+				strings.ReplaceAll(joinFitStrategies(), ", ", "|"),
+			)
+		},
+		set:     func(prefs *domain.Preferences, value string) { prefs.Fit = domain.FitStrategy(value) },
+		unknown: "Unknown strategy, available: " + joinFitStrategies(),
+		saved:   "Long emotes will be shortened with: ",
+	})
+}
+
+// FormatResponse shows or changes the format the user's emotes are converted to.
+func (h *Handler) FormatResponse(message *tgbotapi.Message) {
+	h.preferenceResponse(message, "MediaHandler.FormatResponse", preference{
+		values: stringValues(outputFormatNames()),
+		describe: func(prefs domain.Preferences) string {
+			current := prefs.Format
+			if current == "" {
+				current = defaultOutputFormat
+			}
+
+			var b strings.Builder
+
+			fmt.Fprintf(&b, "Emotes are converted to: %s\n", current)
+			fmt.Fprintf(&b, "Usage: /format <%s>\n", strings.ReplaceAll(joinOutputFormats(), ", ", "|"))
+
+			for _, name := range outputFormatNames() {
+				profile := outputFormats[name].profile
+				fmt.Fprintf(
+					&b,
+					"%s - %s, %dpx, up to %s and %s\n",
+					name,
+					profile.Name,
+					profile.Dimensions.Side,
+					formatSize(profile.MaxBytes),
+					profile.MaxDuration,
+				)
+			}
+
+			b.WriteString("You can also add format=<format> to a single message.")
+
+			return b.String()
+		},
+		set:     func(prefs *domain.Preferences, value string) { prefs.Format = domain.OutputFormat(value) },
+		unknown: "Unknown format, available: " + joinOutputFormats(),
+		saved:   "Emotes will be converted to: ",
+	})
+}
+
+// preferenceResponse shows the user's preference without command arguments, otherwise validates and saves it.
+func (h *Handler) preferenceResponse(message *tgbotapi.Message, errMsg string, pref preference) {
+	chatID, userID := message.Chat.ID, message.From.ID
+
+	prefs, err := h.storages.Prefs.Get(userID)
+	if err != nil {
+		slog.Error(errMsg, slog.Int64("userID", userID), slog.Any("err", err.Error()))
+		_, _ = h.apis.TgBot.SendMessage(chatID, "Failed to load your settings, please try again later")
+
+		return
+	}
+
+	arg := strings.ToLower(strings.TrimSpace(message.CommandArguments()))
+	if arg == "" {
+		_, _ = h.apis.TgBot.SendMessage(chatID, pref.describe(prefs))
+		return
+	}
+
+	if !slices.Contains(pref.values, arg) {
+		_, _ = h.apis.TgBot.SendMessage(chatID, pref.unknown)
+		return
+	}
+
+	pref.set(&prefs, arg)
+
+	err = h.storages.Prefs.Save(userID, prefs)
+	if err != nil {
+		slog.Error(errMsg, slog.Int64("userID", userID), slog.Any("err", err.Error()))
+		_, _ = h.apis.TgBot.SendMessage(chatID, "Failed to save your settings, please try again later")
+
+		return
+	}
+
+	_, _ = h.apis.TgBot.SendMessage(chatID, pref.saved+arg)
+}
+
+func stringValues[T ~string](values []T) []string {
+	res := make([]string, len(values))
+	for i := range values {
+		res[i] = string(values[i])
+	}
+
+	return res
+}
+
+func formatSize(size int64) string {
+	if size >= 1<<20 {
+		return fmt.Sprintf("%dMB", size>>20)
+	}
+
+	return fmt.Sprintf("%dKB", size>>10)
+}
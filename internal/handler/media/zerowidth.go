@@ -0,0 +1,75 @@
+package media
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"golang.org/x/sync/errgroup"
+
+	"seventv2tg/internal/domain"
+)
+
+// zeroWidthFlags checks 7TV metadata of the emotes. Metadata is optional, so on failure
+// emotes are treated as regular ones.
+func (h *Handler) zeroWidthFlags(ctx context.Context, emoteIDs []string) []bool {
+	flags := make([]bool, len(emoteIDs))
+
+	eg, egCtx := errgroup.WithContext(ctx)
+	for i := range emoteIDs {
+		eg.Go(func() error {
+			info, err := h.apis.SevenTV.GetEmote(egCtx, emoteIDs[i])
+			if err != nil {
+				return err
+			}
+
+			flags[i] = info.ZeroWidth
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		slog.Warn("MediaHandler.zeroWidthFlags", slog.Any("emoteIDs", emoteIDs), slog.Any("err", err.Error()))
+		return make([]bool, len(emoteIDs))
+	}
+
+	return flags
+}
+
+// arrangeZeroWidth moves zero-width emotes above regular ones, keeping link order within both groups,
+// and makes them keep aspect ratio at the base height like 7TV clients render them.
+// Nothing changes if all emotes are regular or all are zero-width.
+func arrangeZeroWidth(paths []string, opts domain.ConvertOptions, zeroWidth []bool) ([]string, domain.ConvertOptions) {
+	if !slices.Contains(zeroWidth, true) || !slices.Contains(zeroWidth, false) {
+		return paths, opts
+	}
+
+	layers := make([]domain.LayerOptions, len(paths))
+	copy(layers, opts.Layers)
+
+	order := make([]int, 0, len(paths))
+	for i := range paths {
+		if !zeroWidth[i] {
+			order = append(order, i)
+		}
+	}
+	for i := range paths {
+		if zeroWidth[i] {
+			order = append(order, i)
+			layers[i].Placement.MatchHeight = true
+		}
+	}
+
+	resPaths := make([]string, 0, len(paths))
+	resLayers := make([]domain.LayerOptions, 0, len(paths))
+
+	for _, i := range order {
+		resPaths = append(resPaths, paths[i])
+		resLayers = append(resLayers, layers[i])
+	}
+
+	opts.Layers = resLayers
+
+	return resPaths, opts
+}
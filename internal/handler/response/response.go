@@ -0,0 +1,18 @@
+// Package response holds replies shared by command handlers.
+package response
+
+import (
+	"log/slog"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type botApi interface {
+	SendMessage(chatID int64, message string) (tgbotapi.Message, error)
+}
+
+// InternalError tells the admin that the command failed and logs the error for details.
+func InternalError(api botApi, chatID int64, op string, err error) {
+	_, _ = api.SendMessage(chatID, "Internal error, see logs for details")
+	slog.Error(op, slog.Int64("chatID", chatID), slog.Any("err", err.Error()))
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/config"
+	"seventv2tg/internal/infrastructure/storage/invites"
+	"seventv2tg/internal/infrastructure/storage/jobs"
+	"seventv2tg/internal/infrastructure/storage/limits"
+	"seventv2tg/internal/infrastructure/storage/preferences"
+	"seventv2tg/internal/infrastructure/storage/settings"
+	"seventv2tg/internal/infrastructure/storage/users"
+)
+
+const (
+	dbFileName  = "bot.db"
+	openTimeout = time.Second * 5
+)
+
+type Storages struct {
+	db *bbolt.DB
+
+	Jobs     *jobs.Repository
+	Limits   *limits.Repository
+	Users    *users.Repository
+	Settings *settings.Repository
+	Invites  *invites.Repository
+	Prefs    *preferences.Repository
+}
+
+func New(cfg *config.Config) (*Storages, error) {
+	const errMsg = "Storage.New"
+
+	err := os.MkdirAll(cfg.Paths.Data, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	db, err := bbolt.Open(filepath.Join(cfg.Paths.Data, dbFileName), 0o600, &bbolt.Options{Timeout: openTimeout})
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	s := &Storages{db: db}
+
+	err = s.initRepositories()
+	if err != nil {
+		_ = db.Close()
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	return s, nil
+}
+
+func (s *Storages) initRepositories() (err error) {
+	if s.Jobs, err = jobs.New(s.db); err != nil {
+		return err
+	}
+
+	if s.Limits, err = limits.New(s.db); err != nil {
+		return err
+	}
+
+	if s.Users, err = users.New(s.db); err != nil {
+		return err
+	}
+
+	if s.Settings, err = settings.New(s.db); err != nil {
+		return err
+	}
+
+	if s.Invites, err = invites.New(s.db); err != nil {
+		return err
+	}
+
+	if s.Prefs, err = preferences.New(s.db); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Storages) Close() error {
+	return errors.Wrap(s.db.Close(), "Storage.Close")
+}
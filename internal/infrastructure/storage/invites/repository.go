@@ -0,0 +1,82 @@
+package invites
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/domain"
+)
+
+var bucketName = []byte("invites")
+
+var (
+	ErrNotFound    = errors.New("invite not found")
+	ErrAlreadyUsed = errors.New("invite already used")
+)
+
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "InvitesRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+func (r *Repository) Create(invite domain.Invite) error {
+	const errMsg = "InvitesRepository.Create"
+
+	data, err := json.Marshal(invite)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(invite.Code), data)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
+
+// Redeem marks invite as used by the user. Each invite can be used once.
+func (r *Repository) Redeem(code string, userID int64) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		data := b.Get([]byte(code))
+		if data == nil {
+			return ErrNotFound
+		}
+
+		var invite domain.Invite
+		if err := json.Unmarshal(data, &invite); err != nil {
+			return err
+		}
+
+		if invite.UsedBy != 0 {
+			return ErrAlreadyUsed
+		}
+
+		invite.UsedBy = userID
+		invite.UsedAt = time.Now()
+
+		data, err := json.Marshal(invite)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(code), data)
+	})
+
+	return errors.Wrap(err, "InvitesRepository.Redeem")
+}
@@ -0,0 +1,224 @@
+package jobs
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/domain"
+)
+
+var bucketName = []byte("jobs")
+
+var ErrNotFound = errors.New("job not found")
+
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "JobsRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Create assigns a new sequential ID to the job and stores it as queued.
+func (r *Repository) Create(job *domain.Job) error {
+	const errMsg = "JobsRepository.Create"
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		job.ID = id
+		job.Status = domain.JobStatusQueued
+		job.CreatedAt = time.Now()
+
+		return put(b, job)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
+
+// SetStatus moves the job to the given status and stamps start/finish times.
+func (r *Repository) SetStatus(id uint64, status domain.JobStatus, jobErr error) error {
+	const errMsg = "JobsRepository.SetStatus"
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		job, err := get(b, id)
+		if err != nil {
+			return err
+		}
+
+		job.Status = status
+		job.Error = ""
+		if jobErr != nil {
+			job.Error = jobErr.Error()
+		}
+
+		switch status {
+		case domain.JobStatusQueued:
+			job.StartedAt = time.Time{}
+		case domain.JobStatusRunning:
+			job.StartedAt = time.Now()
+		case domain.JobStatusDone, domain.JobStatusFailed, domain.JobStatusCanceled:
+			job.FinishedAt = time.Now()
+		}
+
+		return put(b, &job)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
+
+// ListByStatus returns jobs in any of the given statuses ordered by creation.
+func (r *Repository) ListByStatus(statuses ...domain.JobStatus) ([]domain.Job, error) {
+	var res []domain.Job
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+			var job domain.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			if slices.Contains(statuses, job.Status) {
+				res = append(res, job)
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "JobsRepository.ListByStatus")
+	}
+
+	return res, nil
+}
+
+// Prune deletes jobs finished before the given time and returns how many were deleted.
+// Keys follow creation order, so the scan stops at the first job created after the cutoff.
+func (r *Repository) Prune(before time.Time) (int, error) {
+	var pruned int
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		var keys [][]byte
+
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var job domain.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			if !job.CreatedAt.Before(before) {
+				break
+			}
+
+			if !job.FinishedAt.IsZero() && job.FinishedAt.Before(before) {
+				keys = append(keys, k)
+			}
+		}
+
+		// удалять во время обхода курсором нельзя, он пропускает элементы
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		pruned = len(keys)
+
+		return nil
+	})
+
+	return pruned, errors.Wrap(err, "JobsRepository.Prune")
+}
+
+// Stats counts jobs kept in the history, see Prune.
+func (r *Repository) Stats() (domain.JobStats, error) {
+	var stats domain.JobStats
+	var totalDuration time.Duration
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+			var job domain.Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+
+			switch job.Status {
+			case domain.JobStatusQueued:
+				stats.Queued++
+			case domain.JobStatusRunning:
+				stats.Running++
+			case domain.JobStatusDone:
+				stats.Done++
+				totalDuration += job.FinishedAt.Sub(job.StartedAt)
+			case domain.JobStatusFailed:
+				stats.Failed++
+			case domain.JobStatusCanceled:
+				stats.Canceled++
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return stats, errors.Wrap(err, "JobsRepository.Stats")
+	}
+
+	if stats.Done > 0 {
+		stats.AvgDuration = totalDuration / time.Duration(stats.Done)
+	}
+
+	return stats, nil
+}
+
+func get(b *bbolt.Bucket, id uint64) (domain.Job, error) {
+	var job domain.Job
+
+	data := b.Get(key(id))
+	if data == nil {
+		return job, ErrNotFound
+	}
+
+	err := json.Unmarshal(data, &job)
+
+	return job, err
+}
+
+func put(b *bbolt.Bucket, job *domain.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key(job.ID), data)
+}
+
+// key encodes id big-endian so that bucket iteration follows creation order.
+func key(id uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, id)
+
+	return k
+}
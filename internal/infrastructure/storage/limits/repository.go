@@ -0,0 +1,67 @@
+package limits
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/domain"
+)
+
+var bucketName = []byte("limits")
+
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "LimitsRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Get returns stored quota of the user or an empty one if user has none yet.
+func (r *Repository) Get(userID int64) (domain.UserQuota, error) {
+	quota := domain.UserQuota{Buckets: make(map[string]domain.RateBucket)}
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketName).Get(key(userID))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &quota)
+	})
+
+	return quota, errors.Wrap(err, "LimitsRepository.Get")
+}
+
+func (r *Repository) Save(userID int64, quota domain.UserQuota) error {
+	const errMsg = "LimitsRepository.Save"
+
+	data, err := json.Marshal(quota)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put(key(userID), data)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
+
+func key(userID int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(userID))
+
+	return k
+}
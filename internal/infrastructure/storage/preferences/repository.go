@@ -0,0 +1,67 @@
+package preferences
+
+import (
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/domain"
+)
+
+var bucketName = []byte("preferences")
+
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "PreferencesRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Get returns stored preferences of the user or empty ones if user has not set any.
+func (r *Repository) Get(userID int64) (domain.Preferences, error) {
+	var prefs domain.Preferences
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketName).Get(key(userID))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, &prefs)
+	})
+
+	return prefs, errors.Wrap(err, "PreferencesRepository.Get")
+}
+
+func (r *Repository) Save(userID int64, prefs domain.Preferences) error {
+	const errMsg = "PreferencesRepository.Save"
+
+	data, err := json.Marshal(prefs)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put(key(userID), data)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
+
+func key(userID int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(userID))
+
+	return k
+}
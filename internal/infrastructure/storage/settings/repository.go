@@ -0,0 +1,56 @@
+package settings
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+var bucketName = []byte("settings")
+
+// Repository keeps bot-wide settings as JSON values under string keys.
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "SettingsRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Get decodes value stored under the key into v. v is left untouched if key is not set.
+func (r *Repository) Get(key string, v any) error {
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket(bucketName).Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, v)
+	})
+
+	return errors.Wrap(err, "SettingsRepository.Get")
+}
+
+func (r *Repository) Put(key string, v any) error {
+	const errMsg = "SettingsRepository.Put"
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put([]byte(key), data)
+	})
+
+	return errors.Wrap(err, errMsg)
+}
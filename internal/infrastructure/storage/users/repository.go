@@ -0,0 +1,177 @@
+package users
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+
+	"seventv2tg/internal/domain"
+)
+
+var bucketName = []byte("users")
+
+// lastSeenPrecision limits how often Touch writes to the database for an active user.
+const lastSeenPrecision = 10 * time.Minute
+
+type Repository struct {
+	db *bbolt.DB
+}
+
+func New(db *bbolt.DB) (*Repository, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucketName)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "UsersRepository.New")
+	}
+
+	return &Repository{db: db}, nil
+}
+
+// Touch stores user contact info and returns the stored user with its ban status.
+// Zero chatID keeps previously stored chat. Last seen time is updated with lastSeenPrecision,
+// so that unchanged users are not written on every update.
+func (r *Repository) Touch(userID, chatID int64, username string) (domain.User, error) {
+	var user domain.User
+	var known bool
+
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket(bucketName)
+		known = b.Get(key(userID)) != nil
+		user, err = get(b, userID)
+
+		return err
+	})
+	if err != nil {
+		return user, errors.Wrap(err, "UsersRepository.Touch")
+	}
+
+	if known &&
+		(chatID == 0 || chatID == user.ChatID) &&
+		username == user.Username &&
+		time.Since(user.LastSeenAt) < lastSeenPrecision {
+		return user, nil
+	}
+
+	err = r.db.Update(func(tx *bbolt.Tx) (err error) {
+		b := tx.Bucket(bucketName)
+
+		user, err = get(b, userID)
+		if err != nil {
+			return err
+		}
+
+		if chatID != 0 {
+			user.ChatID = chatID
+		}
+		user.Username = username
+		user.LastSeenAt = time.Now()
+
+		return put(b, user)
+	})
+
+	return user, errors.Wrap(err, "UsersRepository.Touch")
+}
+
+func (r *Repository) SetBanned(userID int64, banned bool) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		user, err := get(b, userID)
+		if err != nil {
+			return err
+		}
+
+		user.Banned = banned
+
+		return put(b, user)
+	})
+
+	return errors.Wrap(err, "UsersRepository.SetBanned")
+}
+
+func (r *Repository) SetAllowed(userID int64, allowed bool) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(bucketName)
+
+		user, err := get(b, userID)
+		if err != nil {
+			return err
+		}
+
+		user.Allowed = allowed
+
+		return put(b, user)
+	})
+
+	return errors.Wrap(err, "UsersRepository.SetAllowed")
+}
+
+// Get returns stored user or a new one if user is not known yet.
+func (r *Repository) Get(userID int64) (domain.User, error) {
+	var user domain.User
+
+	err := r.db.View(func(tx *bbolt.Tx) (err error) {
+		user, err = get(tx.Bucket(bucketName), userID)
+		return err
+	})
+
+	return user, errors.Wrap(err, "UsersRepository.Get")
+}
+
+func (r *Repository) List() ([]domain.User, error) {
+	var res []domain.User
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).ForEach(func(_, v []byte) error {
+			var user domain.User
+			if err := json.Unmarshal(v, &user); err != nil {
+				return err
+			}
+
+			res = append(res, user)
+
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "UsersRepository.List")
+	}
+
+	return res, nil
+}
+
+// get returns stored user or a new one if user is not known yet.
+func get(b *bbolt.Bucket, userID int64) (domain.User, error) {
+	data := b.Get(key(userID))
+	if data == nil {
+		now := time.Now()
+
+		return domain.User{ID: userID, FirstSeenAt: now, LastSeenAt: now}, nil
+	}
+
+	var user domain.User
+	err := json.Unmarshal(data, &user)
+
+	return user, err
+}
+
+func put(b *bbolt.Bucket, user domain.User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+
+	return b.Put(key(user.ID), data)
+}
+
+func key(userID int64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, uint64(userID))
+
+	return k
+}
@@ -1,6 +1,8 @@
 package seventv
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -10,11 +12,22 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+
+	"seventv2tg/internal/domain"
 )
 
 const (
 	maxDownloadSize = 10 << 20
 	defaultTimeout  = time.Second * 10
+
+	zeroWidthFlag = 1 << 8
+)
+
+var (
+	ErrEmoteNotFound     = errors.New("emote not found")
+	ErrFileTooLarge      = fmt.Errorf("input file too large (>%dMB)", maxDownloadSize>>20)
+	ErrUnsupportedFormat = errors.New("invalid content type")
+	ErrUnavailable       = errors.New("7tv is unavailable")
 )
 
 func New(saveDir string) *API {
@@ -31,27 +44,77 @@ type API struct {
 	client  *http.Client
 }
 
-func (a *API) DownloadWebp(emoteID string) (string, error) {
+type emoteResponse struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Flags int    `json:"flags"`
+}
+
+// GetEmote returns emote metadata from 7TV API.
+func (a *API) GetEmote(ctx context.Context, emoteID string) (domain.EmoteInfo, error) {
+	const errMsg = "SevenTvAPI.GetEmote"
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://7tv.io/v3/emotes/"+emoteID, nil)
+	if err != nil {
+		return domain.EmoteInfo{}, errors.Wrap(err, errMsg)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return domain.EmoteInfo{}, errors.Wrap(errors.WithMessage(ErrUnavailable, err.Error()), errMsg)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return domain.EmoteInfo{}, errors.Wrap(ErrEmoteNotFound, errMsg)
+	case resp.StatusCode != http.StatusOK:
+		err = errors.WithMessage(ErrUnavailable, "response status code "+resp.Status)
+
+		return domain.EmoteInfo{}, errors.Wrap(err, errMsg)
+	}
+
+	var emote emoteResponse
+
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxDownloadSize)).Decode(&emote)
+	if err != nil {
+		return domain.EmoteInfo{}, errors.Wrap(err, errMsg)
+	}
+
+	return domain.EmoteInfo{
+		ID:        emote.ID,
+		Name:      emote.Name,
+		ZeroWidth: emote.Flags&zeroWidthFlag != 0,
+	}, nil
+}
+
+func (a *API) DownloadWebp(ctx context.Context, emoteID string) (string, error) {
 	const errMsg = "SevenTvAPI.DownloadWebp"
 
 	cdnUrl := fmt.Sprintf("https://cdn.7tv.app/emote/%s/4x.webp", emoteID)
 
-	resp, err := a.client.Get(cdnUrl)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, cdnUrl, nil)
 	if err != nil {
 		return "", errors.Wrap(err, errMsg)
 	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(errors.WithMessage(ErrUnavailable, err.Error()), errMsg)
+	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		err = errors.New("response status code " + resp.Status)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", errors.Wrap(ErrEmoteNotFound, errMsg)
+	case resp.StatusCode != http.StatusOK:
+		err = errors.WithMessage(ErrUnavailable, "response status code "+resp.Status)
 
 		return "", errors.Wrap(err, errMsg)
 	}
 
 	if resp.Header.Get("Content-Type") != "image/webp" {
-		err = errors.New("invalid content type")
-
-		return "", errors.Wrap(err, errMsg)
+		return "", errors.Wrap(ErrUnsupportedFormat, errMsg)
 	}
 
 	limitedReader := io.LimitReader(resp.Body, maxDownloadSize+1)
@@ -66,14 +129,14 @@ func (a *API) DownloadWebp(emoteID string) (string, error) {
 
 	written, err := io.Copy(outFile, limitedReader)
 	if err != nil {
+		_ = os.Remove(outPath)
 		return "", errors.Wrap(err, errMsg)
 	}
 
 	if written > maxDownloadSize {
 		_ = os.Remove(outPath)
-		err = fmt.Errorf("input file too large (>%dMB)", maxDownloadSize>>20)
 
-		return "", errors.Wrap(err, errMsg)
+		return "", errors.Wrap(ErrFileTooLarge, errMsg)
 	}
 
 	return outPath, nil
@@ -52,6 +52,22 @@ func (b *API) SendAttachment(attachment tgbotapi.Chattable) error {
 	return errors.Wrap(err, errMsg)
 }
 
+func (b *API) IsChatMember(chatID, userID int64) (bool, error) {
+	const errMsg = "BotAPI.IsChatMember"
+
+	member, err := b.bot.GetChatMember(tgbotapi.GetChatMemberConfig{
+		ChatConfigWithUser: tgbotapi.ChatConfigWithUser{
+			ChatID: chatID,
+			UserID: userID,
+		},
+	})
+	if err != nil {
+		return false, errors.Wrap(err, errMsg)
+	}
+
+	return !member.HasLeft() && !member.WasKicked(), nil
+}
+
 func (b *API) GetUpdatesChan() tgbotapi.UpdatesChannel {
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
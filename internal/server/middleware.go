@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"seventv2tg/internal/handler/media"
+	"seventv2tg/internal/server/router"
+	"seventv2tg/internal/service/scheduler"
+)
+
+const internalErrorMessage = "Internal error, please try again later"
+
+func (s *Server) recoverer(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			s.services.Metrics.UpdatePanics.Add(1)
+
+			var userID, chatID int64
+			if user := u.SentFrom(); user != nil {
+				userID = user.ID
+			}
+			if chat := u.FromChat(); chat != nil {
+				chatID = chat.ID
+				s.handlers.General.MessageResponse(chatID, internalErrorMessage)
+			}
+
+			slog.Error(
+				"Panic while handling update",
+				slog.Int("updateID", u.UpdateID),
+				slog.Int64("userID", userID),
+				slog.Int64("chatID", chatID),
+				slog.Any("panic", rec),
+				slog.String("stack", string(debug.Stack())),
+			)
+		}()
+
+		next(ctx, u)
+	}
+}
+
+func (s *Server) logger(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		startedAt := time.Now()
+
+		next(ctx, u)
+
+		attrs := []any{slog.Int("updateID", u.UpdateID), slog.Duration("took", time.Since(startedAt))}
+		if user := u.SentFrom(); user != nil {
+			attrs = append(attrs, slog.Int64("userID", user.ID))
+		}
+		if u.Message != nil && u.Message.IsCommand() {
+			attrs = append(attrs, slog.String("command", u.Message.Command()))
+		}
+
+		slog.Debug("Update handled", attrs...)
+	}
+}
+
+// bannedFilter drops updates without sender and updates from banned users.
+func (s *Server) bannedFilter(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		user := u.SentFrom()
+		if user == nil {
+			return
+		}
+
+		var chatID int64
+		if chat := u.FromChat(); chat != nil {
+			chatID = chat.ID
+		}
+
+		if s.handlers.Admin.TrackUser(user, chatID) {
+			return
+		}
+
+		next(ctx, u)
+	}
+}
+
+func (s *Server) adminOnly(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		if !slices.Contains(s.cfg.AdminIDs, u.SentFrom().ID) {
+			return
+		}
+
+		next(ctx, u)
+	}
+}
+
+func (s *Server) accessControl(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		if !s.handlers.Access.HasAccess(u.SentFrom().ID, u.FromChat().ID) {
+			return
+		}
+
+		next(ctx, u)
+	}
+}
+
+func (s *Server) maintenance(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		userID := u.SentFrom().ID
+
+		status := s.services.Maintenance.Status()
+		if !status.Enabled || slices.Contains(s.cfg.AdminIDs, userID) || slices.Contains(s.cfg.MaintenanceTesterIDs, userID) {
+			next(ctx, u)
+			return
+		}
+
+		chatID := u.FromChat().ID
+
+		s.handlers.General.MaintenanceNotice(chatID, status)
+
+		err := s.services.Maintenance.TurnAway(chatID)
+		if err != nil {
+			slog.Error("Server.maintenance", slog.Int64("chatID", chatID), slog.Any("err", err.Error()))
+		}
+	}
+}
+
+// parseRequest parses the emote message once for the middlewares after it and the handler.
+func (s *Server) parseRequest(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		var req media.Request
+		req.EmoteIDs, req.Options, req.Err = s.handlers.Media.ParseRequest(u.Message.Text)
+
+		next(media.WithRequest(ctx, req), u)
+	}
+}
+
+// admission turns away emote requests while the queue is overloaded. It runs before rateLimit,
+// so requests the bot is too busy to serve do not take user quota.
+func (s *Server) admission(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		if req, ok := media.RequestFrom(ctx); !ok || req.Err != nil {
+			next(ctx, u)
+			return
+		}
+
+		if s.services.Scheduler.ClassOf(u.SentFrom().ID) != scheduler.ClassAdmin {
+			err := s.services.Admission.Admit(s.services.Scheduler.Len())
+			if err != nil {
+				s.handlers.Media.RejectResponse(u.Message.Chat.ID, err)
+				return
+			}
+		}
+
+		next(ctx, u)
+	}
+}
+
+// rateLimit takes user quota for messages that are valid emote requests, invalid ones are
+// passed through to be answered by the handler.
+func (s *Server) rateLimit(next router.HandlerFunc) router.HandlerFunc {
+	return func(ctx context.Context, u *tgbotapi.Update) {
+		req, ok := media.RequestFrom(ctx)
+		if !ok || req.Err != nil {
+			next(ctx, u)
+			return
+		}
+
+		err := s.services.Limiter.Allow(u.SentFrom().ID, len(req.EmoteIDs) > 1)
+		if err != nil {
+			s.handlers.Media.RejectResponse(u.Message.Chat.ID, err)
+			return
+		}
+
+		next(ctx, u)
+	}
+}
@@ -0,0 +1,91 @@
+package router
+
+import (
+	"context"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type (
+	HandlerFunc func(ctx context.Context, update *tgbotapi.Update)
+	Middleware  func(next HandlerFunc) HandlerFunc
+
+	// Router dispatches updates to registered handlers. Global middlewares registered
+	// with Use wrap every update, route middlewares wrap only their handler.
+	Router struct {
+		middlewares []Middleware
+
+		commands  map[string]HandlerFunc
+		callbacks map[string]HandlerFunc
+		text      HandlerFunc
+		inline    HandlerFunc
+	}
+)
+
+// CallbackSeparator splits callback data into route prefix and payload, e.g. "layout:grid".
+const CallbackSeparator = ":"
+
+func New() *Router {
+	return &Router{
+		commands:  make(map[string]HandlerFunc),
+		callbacks: make(map[string]HandlerFunc),
+	}
+}
+
+func (r *Router) Use(mws ...Middleware) {
+	r.middlewares = append(r.middlewares, mws...)
+}
+
+func (r *Router) Command(name string, h HandlerFunc, mws ...Middleware) {
+	r.commands[name] = chain(h, mws)
+}
+
+// Text handles all messages that are not commands.
+func (r *Router) Text(h HandlerFunc, mws ...Middleware) {
+	r.text = chain(h, mws)
+}
+
+func (r *Router) Callback(prefix string, h HandlerFunc, mws ...Middleware) {
+	r.callbacks[prefix] = chain(h, mws)
+}
+
+func (r *Router) Inline(h HandlerFunc, mws ...Middleware) {
+	r.inline = chain(h, mws)
+}
+
+func (r *Router) Handle(ctx context.Context, update *tgbotapi.Update) {
+	chain(r.dispatch, r.middlewares)(ctx, update)
+}
+
+func (r *Router) dispatch(ctx context.Context, update *tgbotapi.Update) {
+	h := r.route(update)
+	if h != nil {
+		h(ctx, update)
+	}
+}
+
+func (r *Router) route(update *tgbotapi.Update) HandlerFunc {
+	switch {
+	case update.Message != nil && update.Message.IsCommand():
+		return r.commands[update.Message.Command()]
+	case update.Message != nil:
+		return r.text
+	case update.CallbackQuery != nil:
+		prefix, _, _ := strings.Cut(update.CallbackQuery.Data, CallbackSeparator)
+		return r.callbacks[prefix]
+	case update.InlineQuery != nil:
+		return r.inline
+	}
+
+	return nil
+}
+
+// chain wraps h so that the first middleware is the outermost one.
+func chain(h HandlerFunc, mws []Middleware) HandlerFunc {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return h
+}
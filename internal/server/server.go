@@ -5,21 +5,31 @@ import (
 	"log"
 	"os"
 	"os/signal"
-	"slices"
-	"sync"
 	"syscall"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"seventv2tg/internal/config"
 	"seventv2tg/internal/handler"
+	"seventv2tg/internal/server/router"
+	"seventv2tg/internal/service"
 )
 
 const (
 	startCommand       = "start"
+	cancelCommand      = "cancel"
 	maintenanceCommand = "maintenance"
-
-	isInMaintenanceMessage = "Bot is currently in maintenance. Try again later."
+	statsCommand       = "stats"
+	queueCommand       = "queue"
+	banCommand         = "ban"
+	unbanCommand       = "unban"
+	broadcastCommand   = "broadcast"
+	accessCommand      = "access"
+	allowCommand       = "allow"
+	disallowCommand    = "disallow"
+	inviteCommand      = "invite"
+	fitCommand         = "fit"
+	formatCommand      = "format"
 )
 
 type botApi interface {
@@ -32,23 +42,29 @@ type (
 		Config   *config.Config
 		Api      botApi
 		Handlers *handler.Handlers
+		Services *service.Services
 	}
 	Server struct {
 		cfg      *config.Config
 		api      botApi
 		handlers *handler.Handlers
-
-		isInMaintenance bool
-		mu              sync.RWMutex
+		services *service.Services
+		router   *router.Router
 	}
 )
 
 func New(p *InitParams) *Server {
-	return &Server{
+	s := &Server{
 		cfg:      p.Config,
 		api:      p.Api,
 		handlers: p.Handlers,
+		services: p.Services,
+		router:   router.New(),
 	}
+
+	s.setupRoutes()
+
+	return s
 }
 
 func (s *Server) Start() {
@@ -57,13 +73,15 @@ func (s *Server) Start() {
 
 	updatesChan := s.api.GetUpdatesChan()
 
+	s.handlers.Media.ResumeJobs()
+
 	log.Println("Server started!")
 	//log.Printf("Start configuration: debug: %t; admins: %v", s.cfg.Debug, s.cfg.AdminIDs)
 
 	for {
 		select {
 		case update := <-updatesChan:
-			go s.handleUpdate(&update)
+			go s.router.Handle(context.Background(), &update)
 		case <-c:
 			s.api.Shutdown()
 
@@ -72,45 +90,66 @@ func (s *Server) Start() {
 	}
 }
 
-func (s *Server) handleUpdate(update *tgbotapi.Update) {
-	if update.Message == nil {
-		return
-	}
-
-	if update.Message.IsCommand() {
-		switch update.Message.Command() {
-		case startCommand:
-			s.handlers.General.StartResponse(update.Message.Chat.ID)
-		case maintenanceCommand:
-			if !slices.Contains(s.cfg.AdminIDs, update.Message.From.ID) {
-				return
-			}
-
-			status := s.switchMaintenanceStatus()
-			s.handlers.General.MaintenanceResponse(update.Message.Chat.ID, status)
-			log.Printf("Maintenance status set to %t by user %d\n", status, update.Message.From.ID)
-		}
-
-		return
-	}
-
-	s.mu.RLock()
-	if s.isInMaintenance {
-		s.mu.RUnlock()
-		s.handlers.General.MessageResponse(update.Message.Chat.ID, isInMaintenanceMessage)
-
-		return
-	}
-	s.mu.RUnlock()
-
-	s.handlers.Media.CreateVideoFromEmote(context.Background(), update.Message)
-}
+func (s *Server) setupRoutes() {
+	r := s.router
 
-func (s *Server) switchMaintenanceStatus() bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	r.Use(s.recoverer, s.logger, s.bannedFilter)
 
-	s.isInMaintenance = !s.isInMaintenance
+	r.Command(startCommand, func(_ context.Context, u *tgbotapi.Update) {
+		if code := u.Message.CommandArguments(); code != "" {
+			s.handlers.Access.RedeemInvite(u.Message.From.ID, u.Message.Chat.ID, code)
+		}
 
-	return s.isInMaintenance
+		s.handlers.General.StartResponse(u.Message.Chat.ID)
+	})
+	r.Command(cancelCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Media.Cancel(u.Message)
+	})
+	r.Command(fitCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Media.FitResponse(u.Message)
+	})
+	r.Command(formatCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Media.FormatResponse(u.Message)
+	})
+
+	r.Command(maintenanceCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.MaintenanceResponse(u.Message.Chat.ID, u.Message.CommandArguments())
+		log.Printf("Maintenance command \"%s\" by user %d\n", u.Message.CommandArguments(), u.Message.From.ID)
+	}, s.adminOnly)
+	r.Command(statsCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.StatsResponse(u.Message.Chat.ID)
+	}, s.adminOnly)
+	r.Command(queueCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.QueueResponse(u.Message.Chat.ID)
+	}, s.adminOnly)
+	r.Command(banCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.BanResponse(u.Message.Chat.ID, u.Message.CommandArguments(), true)
+		log.Printf("User %s banned by user %d\n", u.Message.CommandArguments(), u.Message.From.ID)
+	}, s.adminOnly)
+	r.Command(unbanCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.BanResponse(u.Message.Chat.ID, u.Message.CommandArguments(), false)
+		log.Printf("User %s unbanned by user %d\n", u.Message.CommandArguments(), u.Message.From.ID)
+	}, s.adminOnly)
+	r.Command(broadcastCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Admin.Broadcast(u.Message.Chat.ID, u.Message.CommandArguments())
+		log.Printf("Broadcast started by user %d\n", u.Message.From.ID)
+	}, s.adminOnly)
+
+	r.Command(accessCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Access.ModeResponse(u.Message.Chat.ID, u.Message.CommandArguments())
+		log.Printf("Access command \"%s\" by user %d\n", u.Message.CommandArguments(), u.Message.From.ID)
+	}, s.adminOnly)
+	r.Command(allowCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Access.AllowResponse(u.Message.Chat.ID, u.Message.CommandArguments(), true)
+	}, s.adminOnly)
+	r.Command(disallowCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Access.AllowResponse(u.Message.Chat.ID, u.Message.CommandArguments(), false)
+	}, s.adminOnly)
+	r.Command(inviteCommand, func(_ context.Context, u *tgbotapi.Update) {
+		s.handlers.Access.InviteResponse(u.Message.Chat.ID, u.Message.From.ID)
+	}, s.adminOnly)
+
+	r.Text(func(ctx context.Context, u *tgbotapi.Update) {
+		s.handlers.Media.CreateVideoFromEmote(ctx, u.Message)
+	}, s.accessControl, s.maintenance, s.parseRequest, s.admission, s.rateLimit)
 }
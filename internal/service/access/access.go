@@ -0,0 +1,205 @@
+package access
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/config"
+	"seventv2tg/internal/domain"
+)
+
+const (
+	settingsKey = "access_mode"
+
+	membershipTTL = time.Minute * 10
+	inviteLength  = 8
+)
+
+var ErrGroupNotConfigured = errors.New("access_group_id is not configured")
+
+type (
+	usersRepo interface {
+		Get(userID int64) (domain.User, error)
+		SetAllowed(userID int64, allowed bool) error
+	}
+
+	invitesRepo interface {
+		Create(invite domain.Invite) error
+		Redeem(code string, userID int64) error
+	}
+
+	settingsRepo interface {
+		Get(key string, v any) error
+		Put(key string, v any) error
+	}
+
+	membershipChecker interface {
+		IsChatMember(chatID, userID int64) (bool, error)
+	}
+
+	membership struct {
+		isMember  bool
+		checkedAt time.Time
+	}
+
+	Service struct {
+		adminIDs []int64
+		groupID  int64
+
+		users    usersRepo
+		invites  invitesRepo
+		settings settingsRepo
+		members  membershipChecker
+
+		mode        string
+		memberCache map[int64]membership
+		mu          sync.RWMutex
+	}
+)
+
+func New(
+	cfg *config.Config,
+	users usersRepo,
+	invites invitesRepo,
+	settings settingsRepo,
+	members membershipChecker,
+) *Service {
+	s := &Service{
+		adminIDs:    cfg.AdminIDs,
+		groupID:     cfg.Access.GroupID,
+		users:       users,
+		invites:     invites,
+		settings:    settings,
+		members:     members,
+		mode:        cfg.Access.Mode,
+		memberCache: make(map[int64]membership),
+	}
+
+	var mode string
+
+	err := settings.Get(settingsKey, &mode)
+	if err != nil {
+		slog.Error("Access.New: failed to load mode", slog.Any("err", err.Error()))
+	}
+
+	if config.ValidAccessMode(mode) && (mode != config.AccessModeGroup || s.groupID != 0) {
+		s.mode = mode
+	}
+
+	return s
+}
+
+func (s *Service) Mode() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.mode
+}
+
+func (s *Service) SetMode(mode string) error {
+	const errMsg = "Access.SetMode"
+
+	if !config.ValidAccessMode(mode) {
+		return errors.Wrap(errors.New("unknown access mode "+mode), errMsg)
+	}
+
+	if mode == config.AccessModeGroup && s.groupID == 0 {
+		return errors.Wrap(ErrGroupNotConfigured, errMsg)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.settings.Put(settingsKey, mode)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	s.mode = mode
+
+	return nil
+}
+
+// HasAccess reports whether the user may submit jobs in the current mode.
+// Admins and explicitly allowed users have access in any mode.
+func (s *Service) HasAccess(userID int64) (bool, error) {
+	const errMsg = "Access.HasAccess"
+
+	mode := s.Mode()
+	if mode == config.AccessModeOpen || slices.Contains(s.adminIDs, userID) {
+		return true, nil
+	}
+
+	user, err := s.users.Get(userID)
+	if err != nil {
+		return false, errors.Wrap(err, errMsg)
+	}
+
+	if user.Allowed || mode != config.AccessModeGroup {
+		return user.Allowed, nil
+	}
+
+	isMember, err := s.isGroupMember(userID)
+	if err != nil {
+		return false, errors.Wrap(err, errMsg)
+	}
+
+	return isMember, nil
+}
+
+func (s *Service) SetAllowed(userID int64, allowed bool) error {
+	return errors.Wrap(s.users.SetAllowed(userID, allowed), "Access.SetAllowed")
+}
+
+func (s *Service) CreateInvite(adminID int64) (string, error) {
+	invite := domain.Invite{
+		Code:      uuid.NewString()[:inviteLength],
+		CreatedBy: adminID,
+		CreatedAt: time.Now(),
+	}
+
+	err := s.invites.Create(invite)
+	if err != nil {
+		return "", errors.Wrap(err, "Access.CreateInvite")
+	}
+
+	return invite.Code, nil
+}
+
+// RedeemInvite grants access to the user if the code is valid and not used yet.
+func (s *Service) RedeemInvite(userID int64, code string) error {
+	const errMsg = "Access.RedeemInvite"
+
+	err := s.invites.Redeem(code, userID)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	return errors.Wrap(s.users.SetAllowed(userID, true), errMsg)
+}
+
+func (s *Service) isGroupMember(userID int64) (bool, error) {
+	s.mu.RLock()
+	cached, ok := s.memberCache[userID]
+	s.mu.RUnlock()
+
+	if ok && time.Since(cached.checkedAt) < membershipTTL {
+		return cached.isMember, nil
+	}
+
+	isMember, err := s.members.IsChatMember(s.groupID, userID)
+	if err != nil {
+		return false, err
+	}
+
+	s.mu.Lock()
+	s.memberCache[userID] = membership{isMember: isMember, checkedAt: time.Now()}
+	s.mu.Unlock()
+
+	return isMember, nil
+}
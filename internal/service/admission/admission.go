@@ -0,0 +1,86 @@
+package admission
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"seventv2tg/internal/config"
+)
+
+const (
+	// used until the first job is finished
+	defaultJobDuration = time.Second * 10
+	// weight of the latest observation in the moving average
+	smoothingFactor = 0.2
+)
+
+type (
+	Controller struct {
+		maxQueueSize int
+		maxQueueWait time.Duration
+		workers      int
+
+		avgJobDuration time.Duration
+		mu             sync.RWMutex
+	}
+
+	// BusyError is returned when new job would overload the queue.
+	BusyError struct {
+		RetryIn time.Duration
+	}
+)
+
+func (e *BusyError) Error() string {
+	return fmt.Sprintf("queue is overloaded, retry in %s", e.RetryIn)
+}
+
+func New(cfg config.Admission, workers int) *Controller {
+	return &Controller{
+		maxQueueSize:   cfg.MaxQueueSize,
+		maxQueueWait:   cfg.MaxQueueWait,
+		workers:        max(workers, 1),
+		avgJobDuration: defaultJobDuration,
+	}
+}
+
+// Observe updates average job duration with the duration of finished job.
+func (c *Controller) Observe(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.avgJobDuration = time.Duration(smoothingFactor*float64(d) + (1-smoothingFactor)*float64(c.avgJobDuration))
+}
+
+func (c *Controller) AvgJobDuration() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.avgJobDuration
+}
+
+// EstimateWait returns expected time before job at the given queue position is picked by a worker.
+func (c *Controller) EstimateWait(position int) time.Duration {
+	rounds := (position + c.workers - 1) / c.workers
+
+	return time.Duration(rounds) * c.AvgJobDuration()
+}
+
+// Admit checks whether a new job may be added to the queue of the given length.
+func (c *Controller) Admit(queueLen int) error {
+	var retryIn time.Duration
+
+	if c.maxQueueSize > 0 && queueLen >= c.maxQueueSize {
+		retryIn = c.EstimateWait(queueLen - c.maxQueueSize + 1)
+	}
+
+	if wait := c.EstimateWait(queueLen + 1); c.maxQueueWait > 0 && wait > c.maxQueueWait {
+		retryIn = max(retryIn, wait-c.maxQueueWait)
+	}
+
+	if retryIn > 0 {
+		return &BusyError{RetryIn: retryIn}
+	}
+
+	return nil
+}
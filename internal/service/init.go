@@ -2,15 +2,35 @@ package service
 
 import (
 	"seventv2tg/internal/config"
+	"seventv2tg/internal/infrastructure/storage"
+	"seventv2tg/internal/infrastructure/webapi"
+	"seventv2tg/internal/service/access"
+	"seventv2tg/internal/service/admission"
+	"seventv2tg/internal/service/limiter"
+	"seventv2tg/internal/service/maintenance"
 	"seventv2tg/internal/service/media"
+	"seventv2tg/internal/service/metrics"
+	"seventv2tg/internal/service/scheduler"
 )
 
 type Services struct {
-	Media *media.Converter
+	Media       *media.Converter
+	Limiter     *limiter.Limiter
+	Scheduler   *scheduler.Scheduler
+	Admission   *admission.Controller
+	Maintenance *maintenance.Service
+	Metrics     *metrics.Metrics
+	Access      *access.Service
 }
 
-func New(cfg *config.Config) *Services {
+func New(cfg *config.Config, storages *storage.Storages, apis *webapi.WebAPIs) *Services {
 	return &Services{
-		Media: media.NewMediaConverter(cfg.Paths.Jobs, cfg.Paths.Result, cfg.FfmpegRendererThreads),
+		Media:       media.NewMediaConverter(cfg.Paths.Jobs, cfg.Paths.Result, cfg.FfmpegRendererThreads, cfg.CaptionFonts),
+		Limiter:     limiter.New(cfg.AdminIDs, cfg.RateLimits, storages.Limits),
+		Scheduler:   scheduler.New(cfg.AdminIDs, cfg.PriorityUserIDs),
+		Admission:   admission.New(cfg.Admission, cfg.MediaWorkersCount),
+		Maintenance: maintenance.New(storages.Settings),
+		Metrics:     metrics.New(),
+		Access:      access.New(cfg, storages.Users, storages.Invites, storages.Settings, apis.TgBot),
 	}
 }
@@ -0,0 +1,159 @@
+package limiter
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/config"
+	"seventv2tg/internal/domain"
+)
+
+const (
+	jobsPerMinuteRule     = "jobs_per_minute"
+	jobsPerDayRule        = "jobs_per_day"
+	overlayJobsPerDayRule = "overlay_jobs_per_day"
+)
+
+type (
+	quotaRepo interface {
+		Get(userID int64) (domain.UserQuota, error)
+		Save(userID int64, quota domain.UserQuota) error
+	}
+
+	rule struct {
+		name     string
+		capacity int
+		period   time.Duration
+		overlay  bool // rule only applies to overlay jobs
+	}
+
+	Limiter struct {
+		adminIDs []int64
+		rules    []rule
+		repo     quotaRepo
+
+		mu sync.Mutex
+	}
+
+	// ExceededError is returned when one of the user limits is exhausted.
+	ExceededError struct {
+		Limit   string
+		RetryIn time.Duration
+	}
+)
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("limit of %s exceeded, retry in %s", e.Limit, e.RetryIn)
+}
+
+func New(adminIDs []int64, limits config.RateLimits, repo quotaRepo) *Limiter {
+	l := &Limiter{
+		adminIDs: adminIDs,
+		repo:     repo,
+	}
+
+	for _, r := range []rule{
+		{name: jobsPerMinuteRule, capacity: limits.JobsPerMinute, period: time.Minute},
+		{name: jobsPerDayRule, capacity: limits.JobsPerDay, period: time.Hour * 24},
+		{name: overlayJobsPerDayRule, capacity: limits.OverlayJobsPerDay, period: time.Hour * 24, overlay: true},
+	} {
+		if r.capacity > 0 {
+			l.rules = append(l.rules, r)
+		}
+	}
+
+	return l
+}
+
+// Allow takes a token from every bucket applicable to the job. Tokens are taken only
+// if all buckets have one, otherwise *ExceededError for the longest wait is returned.
+func (l *Limiter) Allow(userID int64, overlay bool) error {
+	const errMsg = "Limiter.Allow"
+
+	if slices.Contains(l.adminIDs, userID) || len(l.rules) == 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	quota, err := l.repo.Get(userID)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	now := time.Now()
+
+	var exceeded *ExceededError
+
+	for _, r := range l.rules {
+		if r.overlay && !overlay {
+			continue
+		}
+
+		bucket := r.refill(quota.Buckets[r.name], now)
+		quota.Buckets[r.name] = bucket
+
+		if bucket.Tokens >= 1 {
+			continue
+		}
+
+		retryIn := time.Duration((1 - bucket.Tokens) / r.rate() * float64(time.Second))
+		if exceeded == nil || retryIn > exceeded.RetryIn {
+			exceeded = &ExceededError{Limit: r.String(), RetryIn: retryIn}
+		}
+	}
+
+	if exceeded != nil {
+		return exceeded
+	}
+
+	for _, r := range l.rules {
+		if r.overlay && !overlay {
+			continue
+		}
+
+		bucket := quota.Buckets[r.name]
+		bucket.Tokens--
+		quota.Buckets[r.name] = bucket
+	}
+
+	return errors.Wrap(l.repo.Save(userID, quota), errMsg)
+}
+
+// rate returns bucket refill speed in tokens per second.
+func (r rule) rate() float64 {
+	return float64(r.capacity) / r.period.Seconds()
+}
+
+func (r rule) refill(b domain.RateBucket, now time.Time) domain.RateBucket {
+	// Новый пользователь начинает с полным бакетом.
+	if b.UpdatedAt.IsZero() {
+		return domain.RateBucket{Tokens: float64(r.capacity), UpdatedAt: now}
+	}
+
+	elapsed := max(now.Sub(b.UpdatedAt).Seconds(), 0)
+
+	return domain.RateBucket{
+		Tokens:    math.Min(float64(r.capacity), b.Tokens+elapsed*r.rate()),
+		UpdatedAt: now,
+	}
+}
+
+func (r rule) String() string {
+	switch r.name {
+	case jobsPerMinuteRule:
+		return fmt.Sprintf("%d emotes per minute", r.capacity)
+	case jobsPerDayRule:
+		return fmt.Sprintf("%d emotes per day", r.capacity)
+	case overlayJobsPerDayRule:
+		return fmt.Sprintf("%d overlayed emotes per day", r.capacity)
+	}
+
+	return r.name
+}
@@ -0,0 +1,110 @@
+package maintenance
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/domain"
+)
+
+const settingsKey = "maintenance"
+
+type (
+	settingsRepo interface {
+		Get(key string, v any) error
+		Put(key string, v any) error
+	}
+
+	Service struct {
+		repo  settingsRepo
+		state domain.Maintenance
+		mu    sync.RWMutex
+	}
+)
+
+func New(repo settingsRepo) *Service {
+	s := &Service{repo: repo}
+
+	err := repo.Get(settingsKey, &s.state)
+	if err != nil {
+		slog.Error("Maintenance.New: failed to load state", slog.Any("err", err.Error()))
+	}
+
+	return s
+}
+
+func (s *Service) Status() domain.Maintenance {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.state
+}
+
+// Enable turns maintenance on or updates reason and ETA of the current one. Zero eta means unknown.
+func (s *Service) Enable(reason string, eta time.Duration) (domain.Maintenance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.state
+	if !state.Enabled {
+		state = domain.Maintenance{Enabled: true, Since: time.Now()}
+	}
+
+	state.Reason = reason
+	state.ETA = time.Time{}
+	if eta > 0 {
+		state.ETA = time.Now().Add(eta)
+	}
+
+	err := s.save(state)
+	if err != nil {
+		return s.state, errors.Wrap(err, "Maintenance.Enable")
+	}
+
+	return s.state, nil
+}
+
+// Disable turns maintenance off and returns chats that were turned away during it.
+func (s *Service) Disable() ([]int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	turnedAway := s.state.TurnedAway
+
+	err := s.save(domain.Maintenance{})
+	if err != nil {
+		return nil, errors.Wrap(err, "Maintenance.Disable")
+	}
+
+	return turnedAway, nil
+}
+
+// TurnAway remembers the chat to notify it when maintenance ends.
+func (s *Service) TurnAway(chatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.state.Enabled || slices.Contains(s.state.TurnedAway, chatID) {
+		return nil
+	}
+
+	state := s.state
+	state.TurnedAway = append(slices.Clone(state.TurnedAway), chatID)
+
+	return errors.Wrap(s.save(state), "Maintenance.TurnAway")
+}
+
+func (s *Service) save(state domain.Maintenance) error {
+	err := s.repo.Put(settingsKey, state)
+	if err != nil {
+		return err
+	}
+
+	s.state = state
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package media
+
+import (
+	_ "embed"
+	"image"
+	"image/color"
+	"image/draw"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+
+	"seventv2tg/internal/domain"
+)
+
+const (
+	captionMaxLines = 2
+	// caption takes at most this share of the sticker height
+	captionMaxHeight = 0.35
+	captionMargin    = 0.03
+	captionMinSize   = 14
+	captionMaxSize   = 96
+	captionSizeStep  = 2
+	// outline width relative to font size
+	captionOutlineWidth = 0.08
+)
+
+// captionEllipsis replaces the end of a caption that does not fit even at captionMinSize.
+const captionEllipsis = "…"
+
+// emojiFont is the last fallback, so emoji are drawn even without configured fonts.
+//
+//go:embed fonts/EmojiOne.otf
+var emojiFont []byte
+
+var (
+	captionFillColor    = color.White
+	captionOutlineColor = color.Black
+)
+
+// captionRenderer draws text with the bundled font, glyphs it lacks are taken from fallback fonts
+// and then from the bundled emoji font.
+type captionRenderer struct {
+	fonts []*opentype.Font
+}
+
+func newCaptionRenderer(fallbackPaths []string) *captionRenderer {
+	primary, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		// встроенный шрифт валиден, сюда не попадаем
+		panic(err)
+	}
+
+	r := &captionRenderer{fonts: []*opentype.Font{primary}}
+
+	for _, path := range fallbackPaths {
+		f, err := loadFont(path)
+		if err != nil {
+			slog.Error("Failed to load caption font", slog.String("path", path), slog.Any("err", err.Error()))
+			continue
+		}
+
+		r.fonts = append(r.fonts, f)
+	}
+
+	emoji, err := opentype.Parse(emojiFont)
+	if err != nil {
+		panic(err)
+	}
+
+	r.fonts = append(r.fonts, emoji)
+
+	return r
+}
+
+func loadFont(path string) (*opentype.Font, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "loadFont")
+	}
+
+	f, err := opentype.Parse(data)
+	if err != nil {
+		// коллекции вроде .ttc - берем первый шрифт
+		collection, errCol := opentype.ParseCollection(data)
+		if errCol != nil {
+			return nil, errors.Wrap(err, "loadFont")
+		}
+
+		f, err = collection.Font(0)
+	}
+
+	return f, errors.Wrap(err, "loadFont")
+}
+
+type (
+	// captionRun is a piece of a line drawn with a single font.
+	captionRun struct {
+		text string
+		font int
+	}
+
+	captionLine struct {
+		runs  []captionRun
+		width fixed.Int26_6
+	}
+)
+
+// render returns transparent image of the given width with the caption, auto-sized to fit
+// into captionMaxLines lines and captionMaxHeight of canvasHeight.
+func (r *captionRenderer) render(text string, width, canvasHeight int) (*image.RGBA, error) {
+	maxWidth := maxCaptionWidth(width)
+	maxHeight := int(float64(canvasHeight) * captionMaxHeight)
+
+	var faces []font.Face
+	var lines []captionLine
+	var fits bool
+
+	for size := max(min(captionMaxSize, maxHeight), captionMinSize); size >= captionMinSize; size -= captionSizeStep {
+		var err error
+
+		faces, err = r.faces(float64(size))
+		if err != nil {
+			return nil, errors.Wrap(err, "captionRenderer.render")
+		}
+
+		lines, fits = r.wrap(text, faces, maxWidth)
+		if fits && lineHeight(faces[0])*len(lines) <= maxHeight {
+			break
+		}
+	}
+
+	if !fits {
+		// на минимальном размере текст не влез - обрезаем с многоточием, чтобы было видно, что он длиннее
+		lines = r.truncate(lines, faces, maxWidth)
+	}
+
+	size := faces[0].Metrics().Height.Ceil()
+	outline := max(1, int(math.Round(float64(size)*captionOutlineWidth)))
+
+	img := image.NewRGBA(image.Rect(0, 0, width, lineHeight(faces[0])*len(lines)+2*outline))
+
+	ascent := faces[0].Metrics().Ascent.Ceil()
+
+	for i, line := range lines {
+		x := (fixed.I(width) - line.width) / 2
+		y := fixed.I(outline + i*lineHeight(faces[0]) + ascent)
+
+		// обводка - текст, нарисованный со сдвигами по кругу
+		for dy := -outline; dy <= outline; dy++ {
+			for dx := -outline; dx <= outline; dx++ {
+				if dx*dx+dy*dy > outline*outline {
+					continue
+				}
+
+				drawLine(img, line, faces, image.NewUniform(captionOutlineColor), x+fixed.I(dx), y+fixed.I(dy))
+			}
+		}
+
+		drawLine(img, line, faces, image.NewUniform(captionFillColor), x, y)
+	}
+
+	return img, nil
+}
+
+func (r *captionRenderer) faces(size float64) ([]font.Face, error) {
+	faces := make([]font.Face, len(r.fonts))
+
+	for i := range r.fonts {
+		face, err := opentype.NewFace(r.fonts[i], &opentype.FaceOptions{
+			Size:    size,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		faces[i] = face
+	}
+
+	return faces, nil
+}
+
+// wrap splits text into lines by words. ok is false if text needs more than captionMaxLines lines
+// or a single word is wider than maxWidth.
+func (r *captionRenderer) wrap(text string, faces []font.Face, maxWidth fixed.Int26_6) (lines []captionLine, ok bool) {
+	ok = true
+
+	var current []string
+
+	for _, word := range strings.Fields(text) {
+		candidate := strings.Join(append(current, word), " ")
+
+		line := r.shape(candidate, faces)
+		if line.width <= maxWidth || len(current) == 0 {
+			if line.width > maxWidth {
+				ok = false
+			}
+
+			current = append(current, word)
+
+			continue
+		}
+
+		lines = append(lines, r.shape(strings.Join(current, " "), faces))
+		current = []string{word}
+
+		if r.shape(word, faces).width > maxWidth {
+			ok = false
+		}
+	}
+
+	if len(current) > 0 {
+		lines = append(lines, r.shape(strings.Join(current, " "), faces))
+	}
+
+	return lines, ok && len(lines) <= captionMaxLines
+}
+
+// truncate keeps captionMaxLines lines no wider than maxWidth, ending cut lines with captionEllipsis.
+func (r *captionRenderer) truncate(lines []captionLine, faces []font.Face, maxWidth fixed.Int26_6) []captionLine {
+	cut := len(lines) > captionMaxLines
+	if cut {
+		lines = lines[:captionMaxLines]
+	}
+
+	for i := range lines {
+		if lines[i].width <= maxWidth && (!cut || i != len(lines)-1) {
+			continue
+		}
+
+		text := []rune(strings.TrimRightFunc(lines[i].text(), unicode.IsSpace))
+		for {
+			line := r.shape(string(text)+captionEllipsis, faces)
+			if line.width <= maxWidth || len(text) == 0 {
+				lines[i] = line
+				break
+			}
+
+			text = []rune(strings.TrimRightFunc(string(text[:len(text)-1]), unicode.IsSpace))
+		}
+	}
+
+	return lines
+}
+
+// shape splits line into runs of the first font having each glyph.
+func (r *captionRenderer) shape(text string, faces []font.Face) captionLine {
+	var line captionLine
+	var buf sfnt.Buffer
+
+	for _, char := range text {
+		fontIdx := 0
+
+		if !unicode.IsSpace(char) {
+			for i := range r.fonts {
+				idx, err := r.fonts[i].GlyphIndex(&buf, char)
+				if err == nil && idx != 0 {
+					fontIdx = i
+					break
+				}
+			}
+		}
+
+		if n := len(line.runs); n > 0 && line.runs[n-1].font == fontIdx {
+			line.runs[n-1].text += string(char)
+		} else {
+			line.runs = append(line.runs, captionRun{text: string(char), font: fontIdx})
+		}
+	}
+
+	for _, run := range line.runs {
+		line.width += font.MeasureString(faces[run.font], run.text)
+	}
+
+	return line
+}
+
+func (l captionLine) text() string {
+	var sb strings.Builder
+	for _, run := range l.runs {
+		sb.WriteString(run.text)
+	}
+
+	return sb.String()
+}
+
+func drawLine(dst draw.Image, line captionLine, faces []font.Face, src image.Image, x, y fixed.Int26_6) {
+	dot := fixed.Point26_6{X: x, Y: y}
+
+	for _, run := range line.runs {
+		d := font.Drawer{Dst: dst, Src: src, Face: faces[run.font], Dot: dot}
+		d.DrawString(run.text)
+		dot = d.Dot
+	}
+}
+
+func maxCaptionWidth(width int) fixed.Int26_6 {
+	return fixed.I(int(float64(width) * (1 - 2*captionMargin)))
+}
+
+func lineHeight(face font.Face) int {
+	return face.Metrics().Height.Ceil()
+}
+
+// captionOffsetY returns overlay y expression for the caption position.
+func captionOffsetY(position domain.CaptionPosition) string {
+	if position == domain.CaptionBottom {
+		return "H-h"
+	}
+
+	return "0"
+}
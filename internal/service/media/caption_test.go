@@ -0,0 +1,72 @@
+package media
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCaptionEmojiFallback(t *testing.T) {
+	r := newCaptionRenderer(nil)
+
+	faces, err := r.faces(captionMinSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := r.shape("hi 😂", faces)
+	if n := len(line.runs); n != 2 || line.runs[1].font != len(r.fonts)-1 {
+		t.Fatalf("runs = %+v, want the emoji drawn with the bundled emoji font", line.runs)
+	}
+}
+
+func TestCaptionEllipsis(t *testing.T) {
+	r := newCaptionRenderer(nil)
+
+	const width, height = 512, 512
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"too many lines", strings.Repeat("caption ", 100)},
+		{"too long word", strings.Repeat("W", 100)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			faces, err := r.faces(captionMinSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			lines, fits := r.wrap(tt.text, faces, maxCaptionWidth(width))
+			if fits {
+				t.Fatal("text fits, test needs a longer one")
+			}
+
+			lines = r.truncate(lines, faces, maxCaptionWidth(width))
+			if len(lines) > captionMaxLines {
+				t.Fatalf("got %d lines, want at most %d", len(lines), captionMaxLines)
+			}
+
+			for _, line := range lines {
+				if line.width > maxCaptionWidth(width) {
+					t.Errorf("line %q is wider than the caption", line.text())
+				}
+			}
+
+			if last := lines[len(lines)-1].text(); !strings.HasSuffix(last, captionEllipsis) {
+				t.Errorf("last line %q is not ellipsized", last)
+			}
+
+			img, err := r.render(tt.text, width, height)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if img.Bounds().Dy() > height {
+				t.Errorf("caption height %d exceeds canvas %d", img.Bounds().Dy(), height)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type (
+	// degradation is a set of quality compromises applied on top of the regular encoding.
+	degradation struct {
+		framerate int
+		duration  time.Duration
+		scale     float64
+		posterize bool
+		// dims are restored by padding after the scale step
+		dims Dimensions
+	}
+
+	// source describes the encoded content so ladder steps that change nothing are skipped.
+	source struct {
+		framerate int
+		duration  float64
+	}
+
+	ladderStep struct {
+		// description of the compromise for the user, it depends on whether the result is padded
+		description func(dims Dimensions) string
+		applies     func(src source) bool
+		apply       func(d *degradation)
+	}
+
+	ladderEncodeFunc func(ctx context.Context, outPath string, bitrate int, d degradation) error
+)
+
+const (
+	degradedFramerate = 15
+	degradedDuration  = 2 * time.Second
+	degradedScale     = 0.75
+	// оставляем 4 старших бита на канал
+	posterizeMask = 0xF0
+)
+
+// ladder is applied step by step, each step keeps the previous ones.
+var ladder = []ladderStep{
+	{
+		description: constDescription(fmt.Sprintf("frame rate reduced to %d fps", degradedFramerate)),
+		applies:     func(src source) bool { return src.framerate > degradedFramerate },
+		apply:       func(d *degradation) { d.framerate = degradedFramerate },
+	},
+	{
+		description: constDescription(fmt.Sprintf("duration trimmed to %s", degradedDuration)),
+		applies:     func(src source) bool { return src.duration > degradedDuration.Seconds() },
+		apply:       func(d *degradation) { d.duration = degradedDuration },
+	},
+	{
+		description: func(dims Dimensions) string {
+			if dims.padFilter() != "" {
+				return fmt.Sprintf("emote scaled down to %d%% with transparent margins", int(degradedScale*100))
+			}
+
+			return fmt.Sprintf("resolution reduced to %d%%", int(degradedScale*100))
+		},
+		applies: func(source) bool { return true },
+		apply:   func(d *degradation) { d.scale = degradedScale },
+	},
+	{
+		description: constDescription("colour detail reduced"),
+		applies:     func(source) bool { return true },
+		apply:       func(d *degradation) { d.posterize = true },
+	},
+}
+
+func constDescription(description string) func(Dimensions) string {
+	return func(Dimensions) string { return description }
+}
+
+// encodeWithLadder runs rate controller and, when even the lowest bitrate does not fit,
+// goes down the degradation ladder recording every compromise made.
+func encodeWithLadder(ctx context.Context, outPath string, p Profile, bitrate int, src source, encode ladderEncodeFunc) (EncodeStats, error) {
+	const errMsg = "encodeWithLadder"
+
+	d := degradation{dims: p.Dimensions}
+	var compromises []string
+	var attempts int
+
+	rc := newRateController(p.MaxBytes, p.MinBitrate)
+
+	for step := -1; step < len(ladder); step++ {
+		if step >= 0 {
+			if !ladder[step].applies(src) {
+				continue
+			}
+
+			ladder[step].apply(&d)
+			compromises = append(compromises, ladder[step].description(p.Dimensions))
+		}
+
+		stats, err := rc.run(ctx, outPath, bitrate, func(ctx context.Context, outPath string, bitrate int) error {
+			return encode(ctx, outPath, bitrate, d)
+		})
+
+		attempts += stats.Attempts
+		stats.Attempts = attempts
+
+		if err == nil {
+			stats.Compromises = compromises
+			return stats, nil
+		}
+
+		if !errors.Is(err, ErrQualityLimitExceeded) {
+			return stats, errors.Wrap(err, errMsg)
+		}
+	}
+
+	return EncodeStats{Attempts: attempts}, errors.Wrap(ErrQualityLimitExceeded, errMsg)
+}
+
+// filters returns ffmpeg filters applying the degradation, empty string if there is nothing to apply.
+func (d degradation) filters() string {
+	var filters []string
+
+	if d.framerate > 0 {
+		filters = append(filters, fmt.Sprintf("fps=%d", d.framerate))
+	}
+
+	if d.scale > 0 {
+		filters = append(filters, d.dims.reduceFilter(d.scale))
+	}
+
+	if d.posterize {
+		filters = append(
+			filters,
+			"format=rgba",
+			fmt.Sprintf("lutrgb=r='bitand(val,%[1]d)':g='bitand(val,%[1]d)':b='bitand(val,%[1]d)'", posterizeMask),
+		)
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// outputDuration returns value for ffmpeg -t, limit is the duration limit of the profile.
+func (d degradation) outputDuration(limit time.Duration) time.Duration {
+	if d.duration > 0 {
+		return min(d.duration, limit)
+	}
+
+	return limit
+}
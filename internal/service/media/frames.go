@@ -0,0 +1,98 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"seventv2tg/internal/service/media/webp"
+)
+
+// Полностью прозрачные кадры при сборке webm превращаются в черные,
+// поэтому ставим в углу почти прозрачную точку.
+var emptyFrameMarker = color.NRGBA{R: 255, A: 26}
+
+func isEmptyFrame(img image.Image) bool {
+	// быстрый путь для типов, которые выдает png.Decode для RGBA картинок
+	switch img := img.(type) {
+	case *image.NRGBA:
+		return isTransparentPix(img.Pix, img.Stride, img.Rect.Dx())
+	case *image.RGBA:
+		return isTransparentPix(img.Pix, img.Stride, img.Rect.Dx())
+	}
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// isTransparentPix checks alpha of every pixel in 4 bytes per pixel buffer.
+func isTransparentPix(pix []byte, stride, width int) bool {
+	for row := 0; row+width*4 <= len(pix); row += stride {
+		for i := row + 3; i < row+width*4; i += 4 {
+			if pix[i] != 0 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func markEmptyFrame(img image.Image) *image.NRGBA {
+	res, ok := img.(*image.NRGBA)
+	if !ok {
+		res = image.NewNRGBA(img.Bounds())
+		draw.Draw(res, res.Rect, img, img.Bounds().Min, draw.Src)
+	}
+
+	res.SetNRGBA(res.Rect.Min.X, res.Rect.Min.Y, emptyFrameMarker)
+
+	return res
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(f, img)
+	if err != nil {
+		_ = f.Close()
+		return errors.Wrap(err, path)
+	}
+
+	return f.Close()
+}
+
+// writeConcat writes ffconcat playlist with exact duration of every frame.
+// The last frame is listed twice, otherwise concat demuxer ignores its duration.
+func writeConcat(path string, anim *webp.Animation, frameMask string) error {
+	var b strings.Builder
+
+	b.WriteString("ffconcat version 1.0\n")
+
+	for i := range anim.Frames {
+		fmt.Fprintf(&b, "file '%s'\nduration %s\n", fmt.Sprintf(frameMask, i), formatSeconds(anim.Frames[i].Delay))
+	}
+
+	if len(anim.Frames) > 0 {
+		fmt.Fprintf(&b, "file '%s'\n", fmt.Sprintf(frameMask, len(anim.Frames)-1))
+	}
+
+	return errors.Wrap(os.WriteFile(path, []byte(b.String()), 0o644), "writeConcat")
+}
@@ -0,0 +1,175 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"seventv2tg/internal/service/media/webp"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+func TestWriteConcat(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name   string
+		delays []time.Duration
+		// normalize runs normalizeDelays first, like loadAnimation does
+		normalize bool
+	}{
+		{name: "single_frame", delays: []time.Duration{0}, normalize: true},
+		{name: "uniform", delays: []time.Duration{50 * ms, 50 * ms, 50 * ms}},
+		{name: "mixed", delays: []time.Duration{20 * ms, 1500 * ms, 33 * ms, 70 * ms}},
+		{name: "short_delays", delays: []time.Duration{0, 5 * ms, 10 * ms, 40 * ms}, normalize: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anim := animWithDelays(tt.delays...)
+			if tt.normalize {
+				anim = normalizeDelays(anim)
+			}
+
+			path := filepath.Join(t.TempDir(), concatFileName)
+
+			err := writeConcat(path, anim, frameMask)
+			if err != nil {
+				t.Fatalf("writeConcat() error = %v", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			golden := filepath.Join("testdata", "concat", tt.name+".golden")
+
+			if *updateGolden {
+				err = os.WriteFile(golden, got, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if string(got) != string(want) {
+				t.Errorf("writeConcat() output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+const (
+	benchFrames = 90
+	benchSide   = 512
+)
+
+// benchAnimation returns an emote where every third frame is fully transparent.
+func benchAnimation() *webp.Animation {
+	anim := &webp.Animation{Width: benchSide, Height: benchSide}
+
+	for i := range benchFrames {
+		img := image.NewRGBA(image.Rect(0, 0, benchSide, benchSide))
+
+		if i%3 != 0 {
+			for y := range benchSide {
+				for x := range benchSide {
+					img.SetRGBA(x, y, color.RGBA{R: uint8(x + i), G: uint8(y), B: uint8(i), A: 255})
+				}
+			}
+		}
+
+		anim.Frames = append(anim.Frames, webp.Frame{Image: img, Delay: 33 * time.Millisecond})
+	}
+
+	return anim
+}
+
+// BenchmarkMarkEmptyFrames compares writing frames with in-process empty frame marking
+// to the former approach: frames written first, then one magick call per frame to find empty ones
+// and another one to mark each of them.
+func BenchmarkMarkEmptyFrames(b *testing.B) {
+	anim := benchAnimation()
+	ctx := context.Background()
+
+	b.Run("in-process", func(b *testing.B) {
+		c := &Converter{videoRendererThreads: 1}
+
+		for b.Loop() {
+			err := c.createSequence(ctx, anim, b.TempDir(), frameMask)
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		b.ReportMetric(float64(b.N*benchFrames)/b.Elapsed().Seconds(), "frames/s")
+	})
+
+	b.Run("magick", func(b *testing.B) {
+		if _, err := exec.LookPath("magick"); err != nil {
+			b.Skip("magick is not installed")
+		}
+
+		for b.Loop() {
+			dir := b.TempDir()
+
+			for i := range anim.Frames {
+				err := writePNG(filepath.Join(dir, fmt.Sprintf(frameMask, i)), anim.Frames[i].Image)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			for i := range anim.Frames {
+				err := magickMarkEmptyFrame(ctx, filepath.Join(dir, fmt.Sprintf(frameMask, i)))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+
+		b.ReportMetric(float64(b.N*benchFrames)/b.Elapsed().Seconds(), "frames/s")
+	})
+}
+
+func magickMarkEmptyFrame(ctx context.Context, path string) error {
+	var out bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "magick", path, "-format", "%[fx:mean]", "info:")
+	cmd.Stdout = &out
+
+	err := cmd.Run()
+	if err != nil {
+		return err
+	}
+
+	mean, err := strconv.ParseFloat(strings.TrimSpace(out.String()), 64)
+	if err != nil || mean != 0 {
+		return err
+	}
+
+	return exec.CommandContext(
+		ctx,
+		"magick", path,
+		"-stroke", "rgba(255,0,0,0.1)",
+		"-strokewidth", "1",
+		"-draw", "point 0,0",
+		path,
+	).Run()
+}
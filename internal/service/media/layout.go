@@ -0,0 +1,165 @@
+package media
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"math"
+	"slices"
+
+	"seventv2tg/internal/domain"
+)
+
+// canvasSize is the longer side emotes are composed at, the result is fit into the profile afterwards.
+const canvasSize = 512
+
+// comboLayout places emotes of given sizes next to each other on a canvas with the longer side of canvasSize.
+// Every emote keeps its aspect ratio.
+func comboLayout(layout domain.Layout, sizes []image.Point) (canvas image.Point, cells []image.Rectangle) {
+	switch layout {
+	case domain.LayoutHorizontal:
+		return lineLayout(sizes, false)
+	case domain.LayoutVertical:
+		return lineLayout(sizes, true)
+	default:
+		return gridLayout(sizes)
+	}
+}
+
+// lineLayout scales emotes to a common height (or width for vertical line) and puts them in a row.
+func lineLayout(sizes []image.Point, vertical bool) (canvas image.Point, cells []image.Rectangle) {
+	aspects := make([]float64, len(sizes))
+
+	var length float64
+	for i := range sizes {
+		aspects[i] = float64(sizes[i].X) / float64(sizes[i].Y)
+		if vertical {
+			aspects[i] = 1 / aspects[i]
+		}
+
+		length += aspects[i]
+	}
+
+	// толщина линии равна 1, масштабируем так, чтобы большая сторона стала canvasSize
+	scale := canvasSize / max(length, 1)
+	thickness := even(scale)
+
+	var pos int
+	for i := range aspects {
+		size := even(aspects[i] * scale)
+		cells = append(cells, image.Rect(pos, 0, pos+size, thickness))
+		pos += size
+	}
+
+	canvas = image.Pt(canvasSize, thickness)
+	if length < 1 {
+		canvas = image.Pt(pos, canvasSize)
+	}
+
+	// остаток от округления делим поровну по краям
+	shift := (canvas.X - pos) / 2
+	for i := range cells {
+		cells[i] = cells[i].Add(image.Pt(shift, 0))
+	}
+
+	if vertical {
+		canvas = transpose(canvas)
+		for i := range cells {
+			cells[i] = image.Rectangle{Min: transpose(cells[i].Min), Max: transpose(cells[i].Max)}
+		}
+	}
+
+	return canvas, cells
+}
+
+// gridLayout puts emotes into equal square cells, each emote is fit into its cell and centered.
+func gridLayout(sizes []image.Point) (canvas image.Point, cells []image.Rectangle) {
+	cols := int(math.Ceil(math.Sqrt(float64(len(sizes)))))
+	rows := (len(sizes) + cols - 1) / cols
+
+	cell := even(float64(canvasSize) / float64(max(cols, rows)))
+
+	for i := range sizes {
+		box := image.Rect(0, 0, cell, cell).Add(image.Pt(i%cols*cell, i/cols*cell))
+
+		scale := min(float64(cell)/float64(sizes[i].X), float64(cell)/float64(sizes[i].Y))
+		size := image.Pt(even(float64(sizes[i].X)*scale), even(float64(sizes[i].Y)*scale))
+
+		offset := box.Min.Add(box.Size().Sub(size).Div(2))
+		cells = append(cells, image.Rectangle{Min: offset, Max: offset.Add(size)})
+	}
+
+	canvas = image.Pt(cols*cell, rows*cell)
+	if cols >= rows {
+		canvas.X = canvasSize
+	} else {
+		canvas.Y = canvasSize
+	}
+
+	return canvas, cells
+}
+
+// even rounds down to an even number, yuva420p needs even dimensions.
+func even(v float64) int {
+	return max(int(v)/2*2, 2)
+}
+
+func transpose(p image.Point) image.Point {
+	return image.Pt(p.Y, p.X)
+}
+
+// drawOrder returns layer indexes sorted by Z, layers with equal Z keep link order.
+func drawOrder(layers []domain.EmoteLayer) []int {
+	order := make([]int, len(layers))
+	for i := range order {
+		order[i] = i
+	}
+
+	slices.SortStableFunc(order, func(a, b int) int {
+		return cmp.Compare(layers[a].Placement.Z, layers[b].Placement.Z)
+	})
+
+	return order
+}
+
+// placementFilters returns filter scaling the layer and overlay position expressions for it.
+// Overlay variables: W and H are base size, w and h are layer size.
+func placementFilters(p domain.Placement) (scale, x, y string) {
+	if p.Scale > 0 && p.Scale != 1 {
+		scale = fmt.Sprintf("scale=trunc(iw*%[1]g/2)*2:trunc(ih*%[1]g/2)*2", p.Scale)
+	}
+
+	var alignX, alignY string
+
+	switch p.Anchor {
+	case domain.AnchorTopLeft, domain.AnchorLeft, domain.AnchorBottomLeft:
+		alignX = "0"
+	case domain.AnchorTopRight, domain.AnchorRight, domain.AnchorBottomRight:
+		alignX = "W-w"
+	default:
+		alignX = "(W-w)/2"
+	}
+
+	switch p.Anchor {
+	case domain.AnchorTopLeft, domain.AnchorTop, domain.AnchorTopRight:
+		alignY = "0"
+	case domain.AnchorBottomLeft, domain.AnchorBottom, domain.AnchorBottomRight:
+		alignY = "H-h"
+	default:
+		alignY = "(H-h)/2"
+	}
+
+	return scale, alignX + offsetExpr(p.OffsetX, "W"), alignY + offsetExpr(p.OffsetY, "H")
+}
+
+// offsetExpr returns signed offset expression, size is the base dimension percents are taken from.
+func offsetExpr(o domain.Offset, size string) string {
+	switch {
+	case o.Value == 0:
+		return ""
+	case o.Percent:
+		return fmt.Sprintf("%+g*%s/100", o.Value, size)
+	default:
+		return fmt.Sprintf("%+g", o.Value)
+	}
+}
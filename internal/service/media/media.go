@@ -1,35 +1,37 @@
 package media
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"image"
 	"log/slog"
 	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"seventv2tg/internal/domain"
+	"seventv2tg/internal/service/media/webp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
-	defaultFramerate = 30
-	defaultBitRate   = 250
-	overlayedBitRate = defaultBitRate + 150 // since we'll have more details we might also increase bitrate
-
-	maxResultSize = 256 << 10
-
-	frameMask = "frame_%03d.png"
+	frameMask      = "frame_%03d.png"
+	concatFileName = "frames.ffconcat"
 
 	autoHeight = 0
 	autoWidth  = 0
 )
 
+var ErrQualityLimitExceeded = errors.New("lower quality limit exceeded")
+
 type (
 	videoStream struct {
 		Width  int `json:"width"`
@@ -40,21 +42,57 @@ type (
 	}
 )
 
-func NewMediaConverter(jobsDir, resDir string, videoRendererThreads int) *Converter {
+func NewMediaConverter(jobsDir, resDir string, videoRendererThreads int, captionFonts []string) *Converter {
 	return &Converter{
 		jobsDir:              jobsDir,
 		resDir:               resDir,
 		videoRendererThreads: videoRendererThreads,
+		captions:             newCaptionRenderer(captionFonts),
 	}
 }
 
+// Result is a converted file with details of how it was encoded.
+type Result struct {
+	Path  string
+	Stats EncodeStats
+}
+
+// sequence is a set of PNG frames ready to be encoded. Frame timings are kept in ffconcat file at path.
+type sequence struct {
+	path      string
+	size      image.Point
+	framerate int
+	duration  float64
+	// resample is set when frames have to be resampled to the profile frame rate
+	resample bool
+	// filters are applied before scaling
+	filters string
+	caption captionImage
+}
+
+// composition describes the canvas layers are drawn on.
+type composition struct {
+	width, height int
+	framerate     int
+	// period is the common loop length of all layers
+	period  time.Duration
+	caption captionImage
+}
+
+// captionImage is a rendered caption put over the result, empty path means no caption.
+type captionImage struct {
+	path     string
+	position domain.CaptionPosition
+}
+
 type Converter struct {
 	jobsDir              string
 	resDir               string
 	videoRendererThreads int
+	captions             *captionRenderer
 }
 
-func (c *Converter) ConvertToVideo(inpFilePath string) (resPath string, err error) {
+func (c *Converter) ConvertToVideo(ctx context.Context, inpFilePath string, p Profile, opts domain.ConvertOptions) (res Result, err error) {
 	const errMsg = "Converter.ConvertToVideo"
 
 	jobID := uuid.NewString()
@@ -66,39 +104,47 @@ func (c *Converter) ConvertToVideo(inpFilePath string) (resPath string, err erro
 		}
 	}()
 
-	framesDirPath := filepath.Join(c.jobsDir, jobID, "frames")
+	m := layerModifiers(opts, 0)
 
-	err = os.MkdirAll(framesDirPath, os.ModePerm)
+	anim, err := c.loadAnimation(inpFilePath, m, opts.Fit, p)
 	if err != nil {
-		return "", errors.Wrap(err, errMsg)
+		return Result{}, errors.Wrap(err, errMsg)
 	}
 
-	framerate, _, err := c.getVideoInfo(inpFilePath)
+	seq, err := c.writeSequence(ctx, anim, filepath.Join(c.jobsDir, jobID, "frames"), m, p.MaxFramerate)
 	if err != nil {
-		return "", errors.Wrap(err, errMsg)
+		return Result{}, errors.Wrap(err, errMsg)
 	}
 
-	err = c.createSequence(inpFilePath, framesDirPath, frameMask)
-	if err != nil {
-		return "", errors.Wrap(err, errMsg)
+	if opts.Caption.Text != "" {
+		size := autoScaledSize(seq.size, p.Dimensions.Side)
+
+		seq.caption, err = c.createCaption(opts.Caption, filepath.Join(c.jobsDir, jobID), size.X, size.Y)
+		if err != nil {
+			return Result{}, errors.Wrap(err, errMsg)
+		}
 	}
 
-	resPath = filepath.Join(c.resDir, jobID+".webm")
+	res.Path = filepath.Join(c.resDir, jobID+p.Ext)
 
-	err = c.createVideoFromSequence(filepath.Join(framesDirPath, frameMask), resPath, framerate, autoHeight, autoWidth)
+	res.Stats, err = c.createVideoFromSequence(ctx, seq, res.Path, p, autoWidth, autoHeight)
 	if err != nil {
-		_ = os.Remove(resPath)
-		return "", errors.Wrap(err, errMsg)
+		return Result{}, errors.Wrap(err, errMsg)
 	}
 
-	return resPath, nil
+	return res, nil
 }
 
-func (c *Converter) OverlayVideos(inpFilePaths []string) (resPath string, err error) {
+func (c *Converter) OverlayVideos(ctx context.Context, inpFilePaths []string, p Profile, opts domain.ConvertOptions) (res Result, err error) {
 	const errMsg = "Converter.OverlayVideos"
 
+	// слои собираются в промежуточные webm с альфой, в формат профиля переводится только итог
+	lp := layerProfile
+	lp.MaxDuration = p.MaxDuration
+	lp.MaxFramerate = p.MaxFramerate
+
 	jobID := uuid.NewString()
-	resPath = filepath.Join(c.resDir, jobID+".webm")
+	res.Path = filepath.Join(c.resDir, jobID+p.Ext)
 
 	defer func() {
 		errFs := os.RemoveAll(filepath.Join(c.jobsDir, jobID))
@@ -107,244 +153,281 @@ func (c *Converter) OverlayVideos(inpFilePaths []string) (resPath string, err er
 		}
 	}()
 
-	var layers []domain.EmoteLayer
-
-	height, width := autoHeight, autoWidth
+	anims := make([]*webp.Animation, len(inpFilePaths))
+	durations := make([]time.Duration, len(inpFilePaths))
 
 	for i := range inpFilePaths {
-		framesDirPath := filepath.Join(c.jobsDir, jobID, fmt.Sprintf("frames-%d", i))
-
-		err = os.MkdirAll(framesDirPath, os.ModePerm)
+		anims[i], err = c.loadAnimation(inpFilePaths[i], layerModifiers(opts, i), opts.Fit, p)
 		if err != nil {
-			return "", errors.Wrap(err, errMsg)
+			return Result{}, errors.Wrap(err, errMsg)
 		}
 
-		framerate, duration, err := c.getVideoInfo(inpFilePaths[i])
-		if err != nil {
-			return "", errors.Wrap(err, errMsg)
-		}
+		durations[i] = anims[i].Duration()
+	}
 
-		err = c.createSequence(inpFilePaths[i], framesDirPath, frameMask)
-		if err != nil {
-			return "", errors.Wrap(err, errMsg)
+	// без выравнивания результат длится как самый длинный слой
+	comp := composition{period: min(slices.Max(durations), p.MaxDuration)}
+
+	// растягиваем слои под общий период, чтобы стикер зацикливался без рывка
+	period, factors, aligned := alignLoops(durations, p.MaxDuration)
+	if aligned {
+		comp.period = period
+		for i := range anims {
+			anims[i] = stretchAnimation(anims[i], factors[i], p.MaxFramerate)
 		}
+	}
 
-		seqPath := filepath.Join(framesDirPath, frameMask)
-		webmPath := filepath.Join(c.jobsDir, jobID, fmt.Sprintf("layer-%d.webm", i))
+	seqs := make([]sequence, len(anims))
+	sizes := make([]image.Point, len(anims))
 
-		err = c.createVideoFromSequence(seqPath, webmPath, framerate, width, height)
-		if err != nil {
-			return "", errors.Wrap(err, errMsg)
-		}
+	for i := range anims {
+		framesDirPath := filepath.Join(c.jobsDir, jobID, fmt.Sprintf("frames-%d", i))
 
-		// use base layer dimensions as reference
-		if i == 0 {
-			width, height, err = c.getVideoDimensions(webmPath)
-			if err != nil {
-				return "", errors.Wrap(err, errMsg)
-			}
+		seqs[i], err = c.writeSequence(ctx, anims[i], framesDirPath, layerModifiers(opts, i), p.MaxFramerate)
+		if err != nil {
+			return Result{}, errors.Wrap(err, errMsg)
 		}
 
-		layers = append(layers, domain.EmoteLayer{
-			WebmPath: webmPath,
-			Duration: duration,
-		})
-	}
-
-	err = c.createOverlayedVideo(layers, resPath)
-	if err != nil {
-		_ = os.Remove(resPath)
-		return "", errors.Wrap(err, errMsg)
+		sizes[i] = seqs[i].size
 	}
 
-	return resPath, nil
-}
+	var layers []domain.EmoteLayer
+	var maxFramerate int
 
-func (c *Converter) createSequence(inpPath, outPath, frameMask string) error {
-	outSeqPath := filepath.Join(outPath, frameMask)
+	height, width := autoHeight, autoWidth
 
-	cmd := exec.Command(
-		"magick",
-		inpPath,
-		"-coalesce",
-		"+repage",
-		outSeqPath,
-	)
-	cmd.Stderr = os.Stderr
+	combo := opts.Layout != "" && opts.Layout != domain.LayoutStack
 
-	err := cmd.Run()
-	if err != nil {
-		return errors.Wrap(err, "createSequence")
+	var cells []image.Rectangle
+	if combo {
+		var canvas image.Point
+		canvas, cells = comboLayout(opts.Layout, sizes)
+		width, height = canvas.X, canvas.Y
 	}
 
-	frames, err := os.ReadDir(outPath)
-	if err != nil {
-		return errors.Wrap(err, "createSequence")
-	}
-
-	for i := range frames {
-		filePath := filepath.Join(outPath, frames[i].Name())
+	for i, seq := range seqs {
+		webmPath := filepath.Join(c.jobsDir, jobID, fmt.Sprintf("layer-%d.webm", i))
 
-		isEmpty, err := c.isEmptyFrame(filePath)
-		if err != nil {
-			return errors.Wrap(err, "createSequence")
+		layer := domain.EmoteLayer{
+			WebmPath: webmPath,
+			Duration: seq.duration,
 		}
 
-		if !isEmpty {
-			continue
+		layerWidth, layerHeight := width, height
+
+		switch {
+		case combo:
+			layerWidth, layerHeight = cells[i].Dx(), cells[i].Dy()
+			layer.Placement = domain.Placement{
+				Anchor:  domain.AnchorTopLeft,
+				OffsetX: domain.Offset{Value: float64(cells[i].Min.X)},
+				OffsetY: domain.Offset{Value: float64(cells[i].Min.Y)},
+			}
+		case i < len(opts.Layers):
+			layer.Placement = opts.Layers[i].Placement
+
+			if layer.Placement.MatchHeight && i > 0 {
+				layerWidth = even(float64(seq.size.X) * float64(height) / float64(seq.size.Y))
+			}
 		}
 
-		err = c.processEmptyFrame(filePath)
+		err = c.assembleSequence(ctx, seq, webmPath, lp, 0, layerWidth, layerHeight, degradation{})
 		if err != nil {
-			return errors.Wrap(err, "createSequence")
+			return Result{}, errors.Wrap(err, errMsg)
 		}
-	}
 
-	return nil
-}
+		maxFramerate = max(maxFramerate, seq.framerate)
 
-func (c *Converter) isEmptyFrame(filepath string) (bool, error) {
-	cmd := exec.Command(
-		"magick",
-		filepath,
-		"-format", "%[fx:mean]",
-		"info:",
-	)
+		// use base layer dimensions as reference
+		if i == 0 && !combo {
+			width, height, err = c.getVideoDimensions(ctx, webmPath)
+			if err != nil {
+				return Result{}, errors.Wrap(err, errMsg)
+			}
+		}
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
-	if err != nil {
-		return false, errors.Wrap(err, "getImageMean")
+		layers = append(layers, layer)
 	}
 
-	meanStr := strings.TrimSpace(out.String())
-	mean, err := strconv.ParseFloat(meanStr, 64)
+	comp.width, comp.height, comp.framerate = width, height, maxFramerate
+
+	if opts.Caption.Text != "" {
+		comp.caption, err = c.createCaption(opts.Caption, filepath.Join(c.jobsDir, jobID), width, height)
+		if err != nil {
+			return Result{}, errors.Wrap(err, errMsg)
+		}
+	}
+
+	res.Stats, err = c.createOverlayedVideo(ctx, layers, res.Path, p, comp)
 	if err != nil {
-		return false, errors.Wrap(err, "getImageMean")
+		return Result{}, errors.Wrap(err, errMsg)
 	}
 
-	return mean == 0, nil
+	return res, nil
 }
 
-func (c *Converter) processEmptyFrame(filepath string) error {
-	// Костыль. Полностью прозрачные кадры при сборке webm превращаются в черные,
-	// поэтому ставим в углу полупрозрачную точку.
-	cmd := exec.Command(
-		"magick",
-		filepath,
-		"-stroke", "rgba(255,0,0,0.1)",
-		"-strokewidth", "1",
-		"-draw", "point 0,0",
-		filepath,
-	)
+// loadAnimation decodes the emote and applies time modifiers and fit strategy within the profile limits.
+func (c *Converter) loadAnimation(inpPath string, m domain.Modifiers, fit domain.FitStrategy, p Profile) (*webp.Animation, error) {
+	anim, err := c.decodeAnimation(inpPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "loadAnimation")
+	}
+
+	anim = normalizeDelays(anim)
+	anim = applyTimeModifiers(anim, m, p.MaxFramerate)
 
-	return errors.Wrap(cmd.Run(), "processEmptyFrame")
+	return fitAnimation(anim, fit, p.MaxDuration, p.MaxFramerate), nil
 }
 
-func (c *Converter) createVideoFromSequence(inpPath, outPath string, framerate, width, height int) error {
-	const errMessage = "createVideoFromSequence"
-	var err error
+// writeSequence writes animation frames to dirPath.
+func (c *Converter) writeSequence(ctx context.Context, anim *webp.Animation, dirPath string, m domain.Modifiers, maxFramerate int) (sequence, error) {
+	const errMsg = "writeSequence"
 
-	bitrate := defaultBitRate
+	err := os.MkdirAll(dirPath, os.ModePerm)
+	if err != nil {
+		return sequence{}, errors.Wrap(err, errMsg)
+	}
 
-	for {
-		err = c.assembleSequence(inpPath, outPath, framerate, bitrate, width, height)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+	err = c.createSequence(ctx, anim, dirPath, frameMask)
+	if err != nil {
+		return sequence{}, errors.Wrap(err, errMsg)
+	}
 
-		fInfo, err := os.Stat(outPath)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+	concatPath := filepath.Join(dirPath, concatFileName)
 
-		if fInfo.Size() <= maxResultSize {
-			return nil
-		}
+	err = writeConcat(concatPath, anim, frameMask)
+	if err != nil {
+		return sequence{}, errors.Wrap(err, errMsg)
+	}
 
-		bitrate, err = c.downscaleVideoParameters(bitrate)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+	seq := sequence{
+		path:    concatPath,
+		size:    rotatedSize(image.Pt(anim.Width, anim.Height), m),
+		filters: modifierFilters(m),
 	}
+	seq.framerate, seq.duration, seq.resample = getVideoInfo(anim, maxFramerate)
+
+	return seq, nil
 }
 
-func (c *Converter) createOverlayedVideo(inpLayers []domain.EmoteLayer, outPath string) error {
-	const errMessage = "createOverlayedVideo"
-	var err error
+func (c *Converter) decodeAnimation(inpPath string) (*webp.Animation, error) {
+	f, err := os.Open(inpPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "decodeAnimation")
+	}
+	defer f.Close()
 
-	bitrate := overlayedBitRate
+	anim, err := webp.Decode(f)
 
-	for {
-		err = c.assembleLayers(inpLayers, outPath, bitrate)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+	return anim, errors.Wrap(err, "decodeAnimation")
+}
 
-		fInfo, err := os.Stat(outPath)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+// createSequence writes coalesced frames as PNG files, up to videoRendererThreads at a time.
+func (c *Converter) createSequence(ctx context.Context, anim *webp.Animation, outPath, frameMask string) error {
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(max(c.videoRendererThreads, 1))
 
-		if fInfo.Size() <= maxResultSize {
-			return nil
-		}
+	for i := range anim.Frames {
+		eg.Go(func() error {
+			if egCtx.Err() != nil {
+				return egCtx.Err()
+			}
 
-		bitrate, err = c.downscaleVideoParameters(bitrate)
-		if err != nil {
-			return errors.Wrap(err, errMessage)
-		}
+			var img image.Image = anim.Frames[i].Image
+			if isEmptyFrame(img) {
+				img = markEmptyFrame(img)
+			}
+
+			return writePNG(filepath.Join(outPath, fmt.Sprintf(frameMask, i)), img)
+		})
 	}
+
+	return errors.Wrap(eg.Wait(), "createSequence")
 }
 
-func (c *Converter) downscaleVideoParameters(bitrate int) (newBitrate int, err error) {
-	const errMessage = "downscaleVideoParameters"
-	const (
-		bitrateThreshold = 150
-		bitrateDropRate  = 20
+func (c *Converter) createVideoFromSequence(ctx context.Context, seq sequence, outPath string, p Profile, width, height int) (EncodeStats, error) {
+	stats, err := encodeWithLadder(
+		ctx,
+		outPath,
+		p,
+		p.Bitrate,
+		source{framerate: seq.framerate, duration: seq.duration},
+		func(ctx context.Context, outPath string, bitrate int, d degradation) error {
+			return c.assembleSequence(ctx, seq, outPath, p, bitrate, width, height, d)
+		},
 	)
 
-	if bitrate > bitrateThreshold {
-		return bitrate - bitrateDropRate, nil
-	}
+	return stats, errors.Wrap(err, "createVideoFromSequence")
+}
 
-	err = errors.New("lower quality limit exceeded")
+func (c *Converter) createOverlayedVideo(ctx context.Context, inpLayers []domain.EmoteLayer, outPath string, p Profile, comp composition) (EncodeStats, error) {
+	stats, err := encodeWithLadder(
+		ctx,
+		outPath,
+		p,
+		p.OverlayedBitrate,
+		source{framerate: comp.framerate, duration: comp.period.Seconds()},
+		func(ctx context.Context, outPath string, bitrate int, d degradation) error {
+			return c.assembleLayers(ctx, inpLayers, outPath, p, bitrate, comp, d)
+		},
+	)
 
-	return 0, errors.Wrap(err, errMessage)
+	return stats, errors.Wrap(err, "createOverlayedVideo")
 }
 
-func (c *Converter) assembleSequence(inpPath, outPath string, framerate, bitrate, width, height int) error {
+func (c *Converter) assembleSequence(ctx context.Context, seq sequence, outPath string, p Profile, bitrate, width, height int, d degradation) error {
 	const errMessage = "assembleSequence"
 
-	var scaleStr string
+	filters := fmt.Sprintf("scale=%d:%d", width, height)
 	if height == autoHeight || width == autoWidth {
-		scaleStr = "'if(gte(iw,ih),512,-1)':'if(gte(ih,iw),512,-1)'"
-	} else {
-		scaleStr = fmt.Sprintf("%d:%d", width, height)
+		filters = p.Dimensions.scaleFilter()
 	}
 
-	cmd := exec.Command(
-		"ffmpeg",
+	if seq.filters != "" {
+		filters = seq.filters + "," + filters
+	}
+	// иначе кадры идут с исходными длительностями (VFR)
+	if seq.resample {
+		filters = fmt.Sprintf("fps=%d,%s", p.MaxFramerate, filters)
+	}
+
+	args := []string{
 		"-y",
 		"-loglevel", "error",
-		"-framerate", fmt.Sprintf("%d", framerate),
-		"-i", inpPath,
-		"-vf", "scale="+scaleStr+",format=yuva420p",
-		"-c:v", "libvpx-vp9",
-		"-b:v", fmt.Sprintf("%dK", bitrate),
-		"-auto-alt-ref", "0",
-		"-an",
+		"-f", "concat",
+		"-i", seq.path,
+	}
+
+	filterFlag := "-vf"
+	if seq.caption.path != "" {
+		filterFlag = "-filter_complex"
+		filters = fmt.Sprintf(
+			"[0] %s [base]; [base][1] overlay=x=(W-w)/2:y=%s",
+			filters, captionOffsetY(seq.caption.position),
+		)
+		args = append(args, "-loop", "1", "-i", seq.caption.path)
+	}
+
+	outDuration := d.outputDuration(p.MaxDuration)
+
+	args = append(args, filterFlag, filters+","+p.outputFilters(d, outDuration))
+	args = append(args, p.encoderArgs(bitrate)...)
+	args = append(
+		args,
 		"-threads", fmt.Sprintf("%d", c.videoRendererThreads),
-		"-t", "3",
+		"-t", formatSeconds(outDuration),
 		outPath,
 	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	cmd.Stderr = os.Stderr
 
 	return errors.Wrap(cmd.Run(), errMessage)
 }
 
-func (c *Converter) assembleLayers(inpLayers []domain.EmoteLayer, outPath string, bitrate int) error {
+// assembleLayers draws layers on a transparent canvas of the base layer size.
+// All layers are looped, the result lasts one common loop period.
+func (c *Converter) assembleLayers(ctx context.Context, inpLayers []domain.EmoteLayer, outPath string, p Profile, bitrate int, comp composition, d degradation) error {
 	const errMessage = "assembleLayers"
 
 	if len(inpLayers) < 2 {
@@ -354,64 +437,79 @@ func (c *Converter) assembleLayers(inpLayers []domain.EmoteLayer, outPath string
 	args := []string{
 		"-y",
 		"-loglevel", "error",
-		"-c:v", "libvpx-vp9",
 	}
 
-	// Если основа короче, чем оверлей - зацикливаем основу.
-	for i := 1; i < len(inpLayers); i++ {
-		if inpLayers[i].Duration > inpLayers[0].Duration {
-			args = append(args, "-stream_loop", "-1")
-			break
-		}
+	for i := range inpLayers {
+		args = append(
+			args,
+			"-stream_loop", "-1",
+			"-c:v", "libvpx-vp9",
+			"-i", inpLayers[i].WebmPath,
+		)
 	}
 
-	args = append(args, "-i", inpLayers[0].WebmPath)
+	filters := strings.Builder{}
+	filters.WriteString(fmt.Sprintf(
+		"color=c=black@0:s=%dx%d:r=%d,format=rgba [bg]",
+		comp.width, comp.height, p.MaxFramerate,
+	))
 
-	overlayString := strings.Builder{}
-	prevLayer := "0"
+	prevLayer := "bg"
 
-	for i := 1; i < len(inpLayers); i++ {
-		inpArgs := []string{
-			"-stream_loop", "-1",
-			"-c:v", "libvpx-vp9",
-			"-i", inpLayers[i].WebmPath,
-		}
+	for n, i := range drawOrder(inpLayers) {
+		scale, x, y := placementFilters(inpLayers[i].Placement)
 
-		args = append(args, inpArgs...)
+		layer := strconv.Itoa(i)
+		if scale != "" {
+			layer = fmt.Sprintf("scaled%d", i)
+			filters.WriteString(fmt.Sprintf("; [%d] %s [%s]", i, scale, layer))
+		}
 
-		currLayer := fmt.Sprintf("tmp%d", i)
+		currLayer := fmt.Sprintf("tmp%d", n)
 
-		overlayString.WriteString(fmt.Sprintf("[%s][%d] overlay=shortest=1", prevLayer, i))
-		if i != len(inpLayers)-1 {
-			overlayString.WriteString(" [" + currLayer + "]; ")
+		filters.WriteString(fmt.Sprintf("; [%s][%s] overlay=x=%s:y=%s", prevLayer, layer, x, y))
+		if n < len(inpLayers)-1 || comp.caption.path != "" {
+			filters.WriteString(" [" + currLayer + "]")
 		}
 
 		prevLayer = currLayer
 	}
 
+	if comp.caption.path != "" {
+		args = append(args, "-loop", "1", "-i", comp.caption.path)
+		filters.WriteString(fmt.Sprintf(
+			"; [%s][%d] overlay=x=(W-w)/2:y=%s",
+			prevLayer, len(inpLayers), captionOffsetY(comp.caption.position),
+		))
+	}
+
+	outDuration := min(d.outputDuration(p.MaxDuration), comp.period)
+
+	if fit := p.Dimensions.fitFilter(); fit != "" {
+		filters.WriteString("," + fit)
+	}
+	filters.WriteString("," + p.outputFilters(d, outDuration))
+
+	args = append(args, "-filter_complex", filters.String())
+	args = append(args, p.encoderArgs(bitrate)...)
 	args = append(
 		args,
-		"-filter_complex", overlayString.String(),
-		"-c:v", "libvpx-vp9",
-		"-pix_fmt", "yuva420p",
-		"-b:v", fmt.Sprintf("%dK", bitrate),
-		"-auto-alt-ref", "0",
-		"-an",
-		"-t", "3",
+		"-t", formatSeconds(outDuration),
 		"-threads", fmt.Sprintf("%d", c.videoRendererThreads),
 		outPath,
 	)
 
-	cmd := exec.Command("ffmpeg", args...)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
 	cmd.Stderr = os.Stderr
 
 	return errors.Wrap(cmd.Run(), errMessage)
 }
 
-func (c *Converter) getVideoDimensions(inpPath string) (width, height int, err error) {
+func (c *Converter) getVideoDimensions(ctx context.Context, inpPath string) (width, height int, err error) {
 	const errMessage = "getVideoDimensions"
 
-	cmd := exec.Command(
+	cmd := exec.CommandContext(
+		ctx,
 		"ffprobe",
 		"-v", "error",
 		"-select_streams", "v:0",
@@ -437,32 +535,54 @@ func (c *Converter) getVideoDimensions(inpPath string) (width, height int, err e
 	return probeOutput.Streams[0].Width, probeOutput.Streams[0].Height, nil
 }
 
-func (c *Converter) getVideoInfo(inpPath string) (framerate int, duration float64, err error) {
-	const errMessage = "getVideoInfo"
+// getVideoInfo returns the highest frame rate within the animation and its duration in seconds.
+// resample is set when some frames are shown faster than maxFramerate allows. Delays are whole milliseconds,
+// so the rate is rounded: 33ms frames are 30 fps and are kept as is.
+func getVideoInfo(anim *webp.Animation, maxFramerate int) (framerate int, duration float64, resample bool) {
+	duration = anim.Duration().Seconds()
 
-	cmd := exec.Command("magick", "identify", "-format", "%T\n", inpPath)
-	output, err := cmd.Output()
-	if err != nil {
-		return 0, 0, errors.Wrap(err, errMessage)
+	if duration == 0 || len(anim.Frames) == 0 {
+		return 1, 0, false
 	}
 
-	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-	totalTime := 0
-	for _, line := range lines {
-		delay, err := strconv.Atoi(strings.TrimSpace(line))
-		if err != nil {
-			return 0, 0, errors.Wrap(err, errMessage)
-		}
-		totalTime += delay
+	shortest := anim.Frames[0].Delay
+	for i := range anim.Frames {
+		shortest = min(shortest, anim.Frames[i].Delay)
 	}
 
-	duration = float64(totalTime) / 100.0
+	rate := int(math.Round(1 / shortest.Seconds()))
+
+	return max(min(rate, maxFramerate), 1), duration, rate > maxFramerate
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64)
+}
 
-	if totalTime == 0 || len(lines) == 0 {
-		return 1, 0, nil
+// createCaption renders caption for a sticker of the given size into dirPath.
+func (c *Converter) createCaption(caption domain.Caption, dirPath string, width, height int) (captionImage, error) {
+	const errMsg = "createCaption"
+
+	img, err := c.captions.render(caption.Text, width, height)
+	if err != nil {
+		return captionImage{}, errors.Wrap(err, errMsg)
 	}
 
-	framerate = min(defaultFramerate, int(math.Round(float64(len(lines))/duration)))
+	path := filepath.Join(dirPath, "caption.png")
+
+	err = writePNG(path, img)
+	if err != nil {
+		return captionImage{}, errors.Wrap(err, errMsg)
+	}
+
+	return captionImage{path: path, position: caption.Position}, nil
+}
+
+// autoScaledSize returns size of the emote scaled so that its longer side is side.
+func autoScaledSize(size image.Point, side int) image.Point {
+	if size.X >= size.Y {
+		return image.Pt(side, int(math.Round(float64(size.Y)*float64(side)/float64(size.X))))
+	}
 
-	return framerate, duration, nil
+	return image.Pt(int(math.Round(float64(size.X)*float64(side)/float64(size.Y))), side)
 }
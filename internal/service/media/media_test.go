@@ -0,0 +1,43 @@
+package media
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetVideoInfo(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name          string
+		delays        []time.Duration
+		wantFramerate int
+		wantDuration  float64
+		wantResample  bool
+	}{
+		{"still image", []time.Duration{0}, 1, 0, false},
+		{"20ms frames", []time.Duration{20 * ms, 20 * ms, 20 * ms}, 30, 0.06, true},
+		{"30 fps", []time.Duration{33 * ms, 33 * ms, 34 * ms}, 30, 0.1, false},
+		{"variable frame rate", []time.Duration{33 * ms, 1500 * ms}, 30, 1.533, false},
+		{"one fast frame", []time.Duration{100 * ms, 16 * ms, 100 * ms}, 30, 0.216, true},
+		{"slow animation", []time.Duration{100 * ms, 250 * ms}, 10, 0.35, false},
+		{"just above limit", []time.Duration{32 * ms, 100 * ms}, 30, 0.132, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			framerate, duration, resample := getVideoInfo(animWithDelays(tt.delays...), 30)
+
+			if framerate != tt.wantFramerate || resample != tt.wantResample {
+				t.Errorf(
+					"getVideoInfo() framerate = %d, resample = %t, want %d, %t",
+					framerate, resample, tt.wantFramerate, tt.wantResample,
+				)
+			}
+
+			if diff := duration - tt.wantDuration; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("getVideoInfo() duration = %g, want %g", duration, tt.wantDuration)
+			}
+		})
+	}
+}
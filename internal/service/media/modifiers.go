@@ -0,0 +1,93 @@
+package media
+
+import (
+	"fmt"
+	"image"
+	"slices"
+	"strings"
+
+	"seventv2tg/internal/domain"
+	"seventv2tg/internal/service/media/webp"
+)
+
+// applyTimeModifiers changes frame order and delays. It runs before the fit strategy,
+// so the strategy sees the final duration.
+func applyTimeModifiers(anim *webp.Animation, m domain.Modifiers, maxFramerate int) *webp.Animation {
+	if !m.Reverse && !m.PingPong && (m.Speed == 0 || m.Speed == 1) {
+		return anim
+	}
+
+	res := *anim
+	res.Frames = slices.Clone(anim.Frames)
+
+	if m.Reverse {
+		slices.Reverse(res.Frames)
+	}
+
+	// обратный проход без крайних кадров, чтобы они не показывались дважды подряд
+	if m.PingPong && len(res.Frames) > 2 {
+		back := slices.Clone(res.Frames[1 : len(res.Frames)-1])
+		slices.Reverse(back)
+		res.Frames = append(res.Frames, back...)
+	}
+
+	if m.Speed > 0 && m.Speed != 1 {
+		res.Frames = limitFramerate(speedUp(res.Frames, 1/m.Speed), maxFramerate)
+	}
+
+	return &res
+}
+
+// modifierFilters returns ffmpeg filters for spatial and colour modifiers, empty string if there are none.
+// They run before scaling, so rotated emotes are scaled by their new dimensions.
+func modifierFilters(m domain.Modifiers) string {
+	var filters []string
+
+	if m.FlipH {
+		filters = append(filters, "hflip")
+	}
+
+	if m.FlipV {
+		filters = append(filters, "vflip")
+	}
+
+	switch m.Rotate {
+	case 90:
+		filters = append(filters, "transpose=clock")
+	case 180:
+		filters = append(filters, "hflip", "vflip")
+	case 270:
+		filters = append(filters, "transpose=cclock")
+	}
+
+	var hue []string
+	if m.Hue != 0 {
+		hue = append(hue, fmt.Sprintf("h=%d", m.Hue))
+	}
+	if m.Grayscale {
+		hue = append(hue, "s=0")
+	}
+	if len(hue) > 0 {
+		filters = append(filters, "hue="+strings.Join(hue, ":"))
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// rotatedSize returns emote size after rotation modifiers.
+func rotatedSize(size image.Point, m domain.Modifiers) image.Point {
+	if m.Rotate == 90 || m.Rotate == 270 {
+		return transpose(size)
+	}
+
+	return size
+}
+
+// layerModifiers returns modifiers of i-th emote of the request.
+func layerModifiers(opts domain.ConvertOptions, i int) domain.Modifiers {
+	if i < len(opts.Layers) {
+		return opts.Layers[i].Modifiers
+	}
+
+	return domain.Modifiers{}
+}
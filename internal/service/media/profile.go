@@ -0,0 +1,267 @@
+package media
+
+import (
+	"fmt"
+	"time"
+)
+
+// palettePixelFormat makes the result use a palette generated from the stream, like GIF does.
+const palettePixelFormat = "pal8"
+
+// Profile describes an output target: encoder settings and limits of the place the result is sent to.
+type Profile struct {
+	// Name is shown to users when the result had to be degraded to fit the limits.
+	Name string
+	// Ext is the result file extension with the leading dot.
+	Ext string
+	// Container is ffmpeg muxer name.
+	Container  string
+	Dimensions Dimensions
+	MaxBytes   int64
+	// MaxDuration also limits the fit strategy and common loop period of composed emotes.
+	MaxDuration  time.Duration
+	MaxFramerate int
+	// Codec is ffmpeg encoder name, CodecArgs are passed right after it.
+	Codec     string
+	CodecArgs []string
+	// PixelFormat of the result, pal8 generates a palette from the stream.
+	PixelFormat string
+	// Transparent is false for formats without alpha channel, transparent areas turn black there.
+	Transparent bool
+	// Bitrate is the first bitrate tried in kbit/s, 0 for encoders without bitrate control.
+	Bitrate int
+	// OverlayedBitrate is used for composed emotes, since they have more details.
+	OverlayedBitrate int
+	// MinBitrate is the lowest bitrate the rate controller tries before degrading the content.
+	MinBitrate int
+}
+
+// Dimensions is the rule the result is scaled by.
+type Dimensions struct {
+	// Side is the longer side in pixels.
+	Side int
+	// Exact keeps the longer side at Side even when content is scaled down to fit the size limit.
+	Exact bool
+	// Square pads the result to Side x Side.
+	Square bool
+	// Even is set for encoders that cannot handle odd dimensions.
+	Even bool
+}
+
+var (
+	// StickerProfile is a Telegram video sticker.
+	StickerProfile = Profile{
+		Name:             "sticker",
+		Ext:              ".webm",
+		Container:        "webm",
+		Dimensions:       Dimensions{Side: 512, Exact: true},
+		MaxBytes:         256 << 10,
+		MaxDuration:      3 * time.Second,
+		MaxFramerate:     30,
+		Codec:            "libvpx-vp9",
+		CodecArgs:        []string{"-auto-alt-ref", "0"},
+		PixelFormat:      "yuva420p",
+		Transparent:      true,
+		Bitrate:          250,
+		OverlayedBitrate: 400,
+		MinBitrate:       150,
+	}
+
+	// EmojiProfile is a Telegram custom emoji.
+	EmojiProfile = Profile{
+		Name:             "custom emoji",
+		Ext:              ".webm",
+		Container:        "webm",
+		Dimensions:       Dimensions{Side: 100, Exact: true, Square: true},
+		MaxBytes:         64 << 10,
+		MaxDuration:      3 * time.Second,
+		MaxFramerate:     30,
+		Codec:            "libvpx-vp9",
+		CodecArgs:        []string{"-auto-alt-ref", "0"},
+		PixelFormat:      "yuva420p",
+		Transparent:      true,
+		Bitrate:          150,
+		OverlayedBitrate: 200,
+		MinBitrate:       50,
+	}
+
+	// AnimationProfile is a Telegram animation.
+	AnimationProfile = Profile{
+		Name:             "animation",
+		Ext:              ".mp4",
+		Container:        "mp4",
+		Dimensions:       Dimensions{Side: 512, Even: true},
+		MaxBytes:         10 << 20,
+		MaxDuration:      10 * time.Second,
+		MaxFramerate:     30,
+		Codec:            "libx264",
+		CodecArgs:        []string{"-movflags", "+faststart"},
+		PixelFormat:      "yuv420p",
+		Bitrate:          1000,
+		OverlayedBitrate: 1200,
+		MinBitrate:       100,
+	}
+
+	// GIFProfile follows Discord emoji limits.
+	GIFProfile = Profile{
+		Name:         "GIF",
+		Ext:          ".gif",
+		Container:    "gif",
+		Dimensions:   Dimensions{Side: 128},
+		MaxBytes:     256 << 10,
+		MaxDuration:  10 * time.Second,
+		MaxFramerate: 30,
+		Codec:        "gif",
+		CodecArgs:    []string{"-loop", "0"},
+		PixelFormat:  palettePixelFormat,
+		Transparent:  true,
+	}
+
+	// APNGProfile follows Discord sticker limits.
+	APNGProfile = Profile{
+		Name:         "APNG",
+		Ext:          ".png",
+		Container:    "apng",
+		Dimensions:   Dimensions{Side: 320},
+		MaxBytes:     512 << 10,
+		MaxDuration:  5 * time.Second,
+		MaxFramerate: 30,
+		Codec:        "apng",
+		CodecArgs:    []string{"-plays", "0"},
+		PixelFormat:  "rgba",
+		Transparent:  true,
+	}
+
+	// WebPProfile follows Slack and Discord emoji limits.
+	WebPProfile = Profile{
+		Name:         "WebP",
+		Ext:          ".webp",
+		Container:    "webp",
+		Dimensions:   Dimensions{Side: 128},
+		MaxBytes:     256 << 10,
+		MaxDuration:  10 * time.Second,
+		MaxFramerate: 30,
+		Codec:        "libwebp_anim",
+		CodecArgs:    []string{"-lossless", "0", "-quality", "75", "-loop", "0"},
+		PixelFormat:  "yuva420p",
+		Transparent:  true,
+	}
+)
+
+// layerProfile encodes intermediate layers of composed emotes. They are near lossless and not limited in size,
+// so only the final encoding makes compromises to fit the target profile.
+var layerProfile = Profile{
+	Name:         "layer",
+	Ext:          ".webm",
+	Container:    "webm",
+	Dimensions:   Dimensions{Side: canvasSize},
+	MaxFramerate: 30,
+	Codec:        "libvpx-vp9",
+	CodecArgs:    []string{"-auto-alt-ref", "0", "-crf", "4", "-b:v", "0", "-deadline", "realtime", "-cpu-used", "8"},
+	PixelFormat:  "yuva420p",
+	Transparent:  true,
+}
+
+// encoderArgs returns ffmpeg output arguments except duration and output path.
+func (p Profile) encoderArgs(bitrate int) []string {
+	args := append([]string{"-c:v", p.Codec}, p.CodecArgs...)
+
+	if p.Bitrate > 0 {
+		args = append(args, "-b:v", fmt.Sprintf("%dK", bitrate))
+	}
+
+	return append(args, "-an", "-f", p.Container)
+}
+
+// outputFilters returns filters finishing the graph: degradation and conversion to the output pixel format.
+// Palette is generated from the stream, so it is trimmed to outDuration for palettegen to see its end.
+func (p Profile) outputFilters(d degradation, outDuration time.Duration) string {
+	filters := d.filters()
+	add := func(f string) {
+		if filters != "" {
+			filters += ","
+		}
+		filters += f
+	}
+
+	if !p.Transparent {
+		// прозрачные области становятся черными, а не цветом из-под альфы
+		add("format=rgba,premultiply=inplace=1")
+	}
+
+	if p.PixelFormat == palettePixelFormat {
+		add(fmt.Sprintf(
+			"trim=duration=%s,split [pal_in][pal_src]; [pal_in] palettegen=reserve_transparent=1 [pal]; "+
+				"[pal_src][pal] paletteuse=alpha_threshold=128",
+			formatSeconds(outDuration),
+		))
+
+		return filters
+	}
+
+	add("format=" + p.PixelFormat)
+
+	return filters
+}
+
+// scaleFilter scales a single emote so that its longer side is Side.
+func (d Dimensions) scaleFilter() string {
+	auto := -1
+	if d.Even {
+		auto = -2
+	}
+
+	filter := fmt.Sprintf("scale='if(gte(iw,ih),%[1]d,%[2]d)':'if(gte(ih,iw),%[1]d,%[2]d)'", d.Side, auto)
+	if d.Square {
+		filter += "," + d.padFilter()
+	}
+
+	return filter
+}
+
+// fitFilter fits composed canvas into the rule, empty string when the canvas already fits.
+func (d Dimensions) fitFilter() string {
+	var filter string
+
+	switch {
+	case d.Side < canvasSize:
+		filter = fmt.Sprintf("scale=%[1]d:%[1]d:force_original_aspect_ratio=decrease:force_divisible_by=2", d.Side)
+	case d.Even:
+		// размеры холста берутся из базового слоя и могут быть нечетными
+		filter = "scale=trunc(iw/2)*2:trunc(ih/2)*2"
+	}
+
+	if d.Square {
+		if filter != "" {
+			filter += ","
+		}
+		filter += d.padFilter()
+	}
+
+	return filter
+}
+
+// reduceFilter scales content by factor keeping aspect ratio, sides the rule requires are padded back.
+func (d Dimensions) reduceFilter(factor float64) string {
+	filter := fmt.Sprintf("scale=trunc(iw*%[1]g/2)*2:trunc(ih*%[1]g/2)*2", factor)
+	if pad := d.padFilter(); pad != "" {
+		filter += "," + pad
+	}
+
+	return filter
+}
+
+// padFilter pads content back to the required size with transparent pixels, empty string if any size is fine.
+func (d Dimensions) padFilter() string {
+	switch {
+	case d.Square:
+		return fmt.Sprintf("pad=%[1]d:%[1]d:(ow-iw)/2:(oh-ih)/2:color=0x00000000", d.Side)
+	case d.Exact:
+		return fmt.Sprintf(
+			"pad='if(gte(iw,ih),%[1]d,iw)':'if(gte(ih,iw),%[1]d,ih)':(ow-iw)/2:(oh-ih)/2:color=0x00000000",
+			d.Side,
+		)
+	}
+
+	return ""
+}
@@ -0,0 +1,155 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// search stops when fitting and overshooting bitrates are this close
+	bitrateStep       = 10
+	maxEncodeAttempts = 6
+
+	// VP9 size grows slightly slower than bitrate, so estimate is aimed a bit below the limit
+	estimateMargin = 0.92
+	// result filling this share of the limit is good enough to stop searching
+	fillRatio = 0.9
+	// small results are re-encoded with at most this multiple of the initial bitrate
+	maxBitrateGrowth = 4
+)
+
+// EncodeStats describes how the result was fit into the size limit.
+type EncodeStats struct {
+	Attempts int
+	// Bitrate in kbit/s of the accepted attempt.
+	Bitrate int
+	Size    int64
+	// Compromises made to fit the limit, see ladder.
+	Compromises []string
+}
+
+type encodeFunc func(ctx context.Context, outPath string, bitrate int) error
+
+// rateController looks for the highest bitrate keeping the result within maxSize.
+// The first attempt gives an estimate of the bitrate filling the limit, down when it overshoots and up when
+// it fills less than fillRatio. After that the search narrows between the best fitting and the lowest
+// overshooting bitrates.
+type rateController struct {
+	maxSize     int64
+	floor       int
+	maxAttempts int
+}
+
+func newRateController(maxSize int64, floor int) *rateController {
+	return &rateController{
+		maxSize:     maxSize,
+		floor:       floor,
+		maxAttempts: maxEncodeAttempts,
+	}
+}
+
+func (rc *rateController) run(ctx context.Context, outPath string, bitrate int, encode encodeFunc) (stats EncodeStats, err error) {
+	const errMsg = "rateController.run"
+
+	var best string
+	// highest fitting and lowest overshooting bitrates
+	var lo, hi int
+	ceiling := bitrate * maxBitrateGrowth
+
+	defer func() {
+		if err != nil && best != "" {
+			_ = os.Remove(best)
+		}
+	}()
+
+	for stats.Attempts < rc.maxAttempts {
+		stats.Attempts++
+
+		path := attemptPath(outPath, stats.Attempts)
+
+		err = encode(ctx, path, bitrate)
+		if err != nil {
+			_ = os.Remove(path)
+			return stats, errors.Wrap(err, errMsg)
+		}
+
+		fInfo, err := os.Stat(path)
+		if err != nil {
+			return stats, errors.Wrap(err, errMsg)
+		}
+
+		size := fInfo.Size()
+
+		if size <= rc.maxSize {
+			if best != "" {
+				_ = os.Remove(best)
+			}
+
+			best, lo = path, bitrate
+			stats.Bitrate, stats.Size = bitrate, size
+
+			if float64(size) >= float64(rc.maxSize)*fillRatio {
+				break
+			}
+		} else {
+			_ = os.Remove(path)
+			hi = bitrate
+		}
+
+		bitrate = rc.next(lo, hi, ceiling, bitrate, size)
+		if bitrate == 0 {
+			break
+		}
+	}
+
+	if best == "" {
+		return stats, errors.Wrap(ErrQualityLimitExceeded, errMsg)
+	}
+
+	err = os.Rename(best, outPath)
+	if err != nil {
+		return stats, errors.Wrap(err, errMsg)
+	}
+
+	return stats, nil
+}
+
+// next returns bitrate for the next attempt or 0 if the search is over.
+func (rc *rateController) next(lo, hi, ceiling, bitrate int, size int64) int {
+	estimate := int(float64(bitrate) * float64(rc.maxSize) / float64(max(size, 1)) * estimateMargin)
+
+	if lo == 0 {
+		if bitrate <= rc.floor {
+			return 0
+		}
+
+		return max(min(estimate, hi-bitrateStep), rc.floor)
+	}
+
+	if hi == 0 {
+		// все попытки влезли с запасом - поднимаем битрейт по оценке, но не выше ceiling
+		estimate = min(estimate, ceiling)
+		if estimate-lo <= bitrateStep {
+			return 0
+		}
+
+		return estimate
+	}
+
+	if hi-lo <= bitrateStep {
+		return 0
+	}
+
+	return (lo + hi) / 2
+}
+
+func attemptPath(outPath string, attempt int) string {
+	ext := filepath.Ext(outPath)
+
+	return fmt.Sprintf("%s.attempt-%d%s", strings.TrimSuffix(outPath, ext), attempt, ext)
+}
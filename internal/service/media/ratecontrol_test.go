@@ -0,0 +1,79 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// linearEncoder writes files growing with bitrate like a real encoder does, bytesPerKbit plus fixed overhead.
+func linearEncoder(bytesPerKbit, overhead int64) encodeFunc {
+	return func(_ context.Context, outPath string, bitrate int) error {
+		return os.WriteFile(outPath, make([]byte, overhead+int64(bitrate)*bytesPerKbit), 0o644)
+	}
+}
+
+func TestRateController(t *testing.T) {
+	const maxSize = 256 << 10
+
+	tests := []struct {
+		name         string
+		bitrate      int
+		floor        int
+		bytesPerKbit int64
+		overhead     int64
+		wantErr      bool
+		// wantFill is the share of maxSize the result has to reach
+		wantFill float64
+	}{
+		{"small first attempt is raised", 250, 150, 320, 0, false, fillRatio},
+		{"large first attempt is lowered", 250, 150, 1500, 0, false, fillRatio},
+		{"lowered down to profile floor", 150, 50, 3000, 0, false, fillRatio},
+		{"raise is capped", 250, 150, 10, 0, false, 0},
+		{"does not fit at floor", 250, 150, 10, maxSize, true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outPath := filepath.Join(t.TempDir(), "out.webm")
+
+			rc := newRateController(maxSize, tt.floor)
+
+			stats, err := rc.run(context.Background(), outPath, tt.bitrate, linearEncoder(tt.bytesPerKbit, tt.overhead))
+			if tt.wantErr {
+				if !errors.Is(err, ErrQualityLimitExceeded) {
+					t.Fatalf("err = %v, want ErrQualityLimitExceeded", err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if stats.Attempts > maxEncodeAttempts {
+				t.Errorf("attempts = %d, want at most %d", stats.Attempts, maxEncodeAttempts)
+			}
+
+			if stats.Bitrate > tt.bitrate*maxBitrateGrowth {
+				t.Errorf("bitrate = %d, want at most %d", stats.Bitrate, tt.bitrate*maxBitrateGrowth)
+			}
+
+			if stats.Size > maxSize || float64(stats.Size) < float64(maxSize)*tt.wantFill {
+				t.Errorf("size = %d, want between %.0f and %d", stats.Size, float64(maxSize)*tt.wantFill, maxSize)
+			}
+
+			info, err := os.Stat(outPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Size() != stats.Size {
+				t.Errorf("result file size = %d, want %d", info.Size(), stats.Size)
+			}
+		})
+	}
+}
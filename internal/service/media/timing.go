@@ -0,0 +1,240 @@
+package media
+
+import (
+	"image"
+	"math"
+	"slices"
+	"time"
+
+	"seventv2tg/internal/domain"
+	"seventv2tg/internal/service/media/webp"
+)
+
+const (
+	// thumbnail side used to compare frames when searching for the best window
+	thumbSize = 16
+
+	// browsers show frames with shorter delay as defaultDelay, 7TV emotes are made to look right there
+	minDelay     = 10 * time.Millisecond
+	defaultDelay = 100 * time.Millisecond
+
+	// max share a layer loop can be sped up or slowed down by to share the period with other layers
+	maxLoopStretch       = 0.1
+	loopDeviationEpsilon = 1e-3
+)
+
+// normalizeDelays replaces too short delays (including zero delay of still images) the way browsers do.
+func normalizeDelays(anim *webp.Animation) *webp.Animation {
+	res := *anim
+	res.Frames = slices.Clone(anim.Frames)
+
+	for i := range res.Frames {
+		if res.Frames[i].Delay <= minDelay {
+			res.Frames[i].Delay = defaultDelay
+		}
+	}
+
+	return &res
+}
+
+// fitAnimation makes animation fit into maxDuration using the strategy, sped up frames are kept within maxFramerate.
+// Truncation is left to ffmpeg, so the animation is returned as is.
+func fitAnimation(anim *webp.Animation, strategy domain.FitStrategy, maxDuration time.Duration, maxFramerate int) *webp.Animation {
+	total := anim.Duration()
+	if total <= maxDuration || len(anim.Frames) < 2 {
+		return anim
+	}
+
+	res := *anim
+
+	switch strategy {
+	case domain.FitSpeedUp:
+		res.Frames = speedUp(anim.Frames, float64(maxDuration)/float64(total))
+		res.Frames = resample(res.Frames, int(maxDuration.Seconds()*float64(maxFramerate)))
+	case domain.FitDropFrames:
+		keep := int(float64(len(anim.Frames)) * float64(maxDuration) / float64(total))
+		res.Frames = resample(anim.Frames, max(keep, 1))
+		res.Frames = speedUp(res.Frames, min(1, float64(maxDuration)/float64(totalDelay(res.Frames))))
+	case domain.FitBestWindow:
+		res.Frames = bestWindow(anim.Frames, maxDuration)
+	}
+
+	return &res
+}
+
+// speedUp scales all frame delays by factor.
+func speedUp(frames []webp.Frame, factor float64) []webp.Frame {
+	res := slices.Clone(frames)
+	for i := range res {
+		res[i].Delay = time.Duration(float64(res[i].Delay) * factor)
+	}
+
+	return res
+}
+
+// resample evenly picks count frames. Delays of dropped frames are added to the previous
+// kept frame, so total duration stays the same.
+func resample(frames []webp.Frame, count int) []webp.Frame {
+	if count <= 0 || count >= len(frames) {
+		return frames
+	}
+
+	res := make([]webp.Frame, 0, count)
+
+	for j := range count {
+		from := j * len(frames) / count
+		to := (j + 1) * len(frames) / count
+
+		frame := frames[from]
+		frame.Delay = totalDelay(frames[from:to])
+
+		res = append(res, frame)
+	}
+
+	return res
+}
+
+// bestWindow picks maxDuration long part of the loop whose end flows into its start most smoothly.
+func bestWindow(frames []webp.Frame, maxDuration time.Duration) []webp.Frame {
+	thumbs := make([][]float64, len(frames))
+	for i := range frames {
+		thumbs[i] = thumbnail(frames[i].Image)
+	}
+
+	bestStart, bestEnd := 0, 0
+	bestScore := math.Inf(1)
+
+	for start := range frames {
+		// end - первый кадр после окна, он должен быть похож на первый кадр окна
+		end, elapsed := start, time.Duration(0)
+		for elapsed+frames[end%len(frames)].Delay <= maxDuration && end-start < len(frames) {
+			elapsed += frames[end%len(frames)].Delay
+			end++
+		}
+
+		if end == start {
+			continue
+		}
+
+		score := thumbDistance(thumbs[start], thumbs[end%len(frames)])
+		if score < bestScore {
+			bestStart, bestEnd, bestScore = start, end, score
+		}
+	}
+
+	if bestEnd == bestStart {
+		return frames
+	}
+
+	res := make([]webp.Frame, 0, bestEnd-bestStart)
+	for i := bestStart; i < bestEnd; i++ {
+		res = append(res, frames[i%len(frames)])
+	}
+
+	return res
+}
+
+func totalDelay(frames []webp.Frame) time.Duration {
+	var total time.Duration
+	for i := range frames {
+		total += frames[i].Delay
+	}
+
+	return total
+}
+
+// thumbnail returns premultiplied RGBA values of a box-downscaled image.
+func thumbnail(img *image.RGBA) []float64 {
+	b := img.Bounds()
+	res := make([]float64, thumbSize*thumbSize*4)
+	counts := make([]float64, thumbSize*thumbSize)
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		ty := (y - b.Min.Y) * thumbSize / b.Dy()
+
+		for x := b.Min.X; x < b.Max.X; x++ {
+			tx := (x - b.Min.X) * thumbSize / b.Dx()
+			cell := ty*thumbSize + tx
+			offset := img.PixOffset(x, y)
+
+			for c := range 4 {
+				res[cell*4+c] += float64(img.Pix[offset+c])
+			}
+			counts[cell]++
+		}
+	}
+
+	for i := range res {
+		if counts[i/4] > 0 {
+			res[i] /= counts[i/4]
+		}
+	}
+
+	return res
+}
+
+func thumbDistance(a, b []float64) float64 {
+	var dist float64
+	for i := range a {
+		dist += math.Abs(a[i] - b[i])
+	}
+
+	return dist
+}
+
+// alignLoops looks for a common loop period of the layers not longer than limit.
+// Each layer is stretched by its factor to fit the period a whole number of times.
+// The period with the least stretching wins, so layers with a short enough LCM are not stretched at all.
+// ok is false if layers can't be aligned without stretching some of them more than maxLoopStretch.
+func alignLoops(durations []time.Duration, limit time.Duration) (period time.Duration, factors []float64, ok bool) {
+	bestDeviation := math.Inf(1)
+
+	for _, d := range durations {
+		if d <= 0 {
+			return 0, nil, false
+		}
+
+		for candidate := d; candidate <= limit; candidate += d {
+			candidateFactors := make([]float64, len(durations))
+
+			var deviation float64
+
+			for j := range durations {
+				loops := max(1, math.Round(float64(candidate)/float64(durations[j])))
+				candidateFactors[j] = float64(candidate) / (loops * float64(durations[j]))
+				deviation = max(deviation, math.Abs(candidateFactors[j]-1))
+			}
+
+			// при равном растяжении берем более короткий период - меньше кадров кодировать
+			if deviation < bestDeviation-loopDeviationEpsilon ||
+				(math.Abs(deviation-bestDeviation) <= loopDeviationEpsilon && candidate < period) {
+				period, factors, bestDeviation = candidate, candidateFactors, deviation
+			}
+		}
+	}
+
+	if bestDeviation > maxLoopStretch {
+		return 0, nil, false
+	}
+
+	return period, factors, true
+}
+
+// stretchAnimation multiplies all frame delays by factor keeping the frame rate within maxFramerate.
+func stretchAnimation(anim *webp.Animation, factor float64, maxFramerate int) *webp.Animation {
+	if factor == 1 {
+		return anim
+	}
+
+	res := *anim
+	res.Frames = limitFramerate(speedUp(anim.Frames, factor), maxFramerate)
+
+	return &res
+}
+
+// limitFramerate drops frames evenly if they are shown faster than maxFramerate.
+func limitFramerate(frames []webp.Frame, maxFramerate int) []webp.Frame {
+	maxFrames := int(math.Ceil(totalDelay(frames).Seconds() * float64(maxFramerate)))
+
+	return resample(frames, max(maxFrames, 1))
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"seventv2tg/internal/service/media/webp"
+)
+
+func animWithDelays(delays ...time.Duration) *webp.Animation {
+	anim := &webp.Animation{Width: 1, Height: 1}
+	for _, d := range delays {
+		anim.Frames = append(anim.Frames, webp.Frame{Delay: d})
+	}
+
+	return anim
+}
+
+func frameDelays(anim *webp.Animation) []time.Duration {
+	delays := make([]time.Duration, len(anim.Frames))
+	for i := range anim.Frames {
+		delays[i] = anim.Frames[i].Delay
+	}
+
+	return delays
+}
+
+func TestNormalizeDelays(t *testing.T) {
+	ms := time.Millisecond
+
+	tests := []struct {
+		name   string
+		delays []time.Duration
+		want   []time.Duration
+	}{
+		{"still image", []time.Duration{0}, []time.Duration{100 * ms}},
+		{"zero delays", []time.Duration{0, 0, 0}, []time.Duration{100 * ms, 100 * ms, 100 * ms}},
+		{"under 10ms", []time.Duration{1 * ms, 9 * ms}, []time.Duration{100 * ms, 100 * ms}},
+		{"exactly 10ms", []time.Duration{10 * ms}, []time.Duration{100 * ms}},
+		{"just above 10ms", []time.Duration{11 * ms, 20 * ms}, []time.Duration{11 * ms, 20 * ms}},
+		{
+			"mixed",
+			[]time.Duration{40 * ms, 0, 1500 * ms, 5 * ms, 33 * ms},
+			[]time.Duration{40 * ms, 100 * ms, 1500 * ms, 100 * ms, 33 * ms},
+		},
+		{"no frames", nil, []time.Duration{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anim := animWithDelays(tt.delays...)
+			orig := frameDelays(anim)
+
+			got := frameDelays(normalizeDelays(anim))
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("normalizeDelays() = %v, want %v", got, tt.want)
+			}
+
+			// исходная анимация не меняется
+			if !slices.Equal(frameDelays(anim), orig) {
+				t.Errorf("normalizeDelays() modified input: %v, was %v", frameDelays(anim), orig)
+			}
+		})
+	}
+}
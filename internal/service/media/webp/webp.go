@@ -0,0 +1,373 @@
+// Package webp decodes animated WebP images into coalesced frames.
+//
+// Single frame bitstreams (VP8, VP8L and ALPH) are decoded by golang.org/x/image/webp,
+// this package handles the extended container: ANIM/ANMF chunks, frame offsets,
+// blending and disposal.
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	xwebp "golang.org/x/image/webp"
+)
+
+const (
+	riffHeaderSize  = 12
+	chunkHeaderSize = 8
+
+	vp8xAlphaFlag = 1 << 4
+
+	anmfHeaderSize        = 16
+	anmfNoBlendFlag       = 1 << 1
+	anmfDisposeFlag       = 1 << 0
+	maxCanvasPixels int64 = 1 << 26
+	// every frame is a full canvas, so the limit is on all of them together: about 512MB of RGBA
+	maxAnimationPixels int64 = 1 << 27
+)
+
+var (
+	ErrInvalidFormat = errors.New("invalid webp format")
+	ErrNoFrames      = errors.New("webp has no frames")
+	ErrTooLarge      = errors.New("webp is too large")
+)
+
+type (
+	Frame struct {
+		// Image is a full canvas with all previous frames applied.
+		Image *image.RGBA
+		Delay time.Duration
+	}
+
+	Animation struct {
+		Width  int
+		Height int
+		// LoopCount is 0 for infinite animation.
+		LoopCount int
+		Frames    []Frame
+	}
+
+	chunk struct {
+		id   string
+		data []byte
+	}
+
+	frameHeader struct {
+		rect      image.Rectangle
+		delay     time.Duration
+		noBlend   bool
+		dispose   bool
+		bitstream []chunk
+	}
+)
+
+// Duration returns total duration of one animation loop.
+func (a *Animation) Duration() time.Duration {
+	var total time.Duration
+	for i := range a.Frames {
+		total += a.Frames[i].Delay
+	}
+
+	return total
+}
+
+// Decode reads still or animated WebP. Still image is returned as a single frame with zero delay.
+func Decode(r io.Reader) (*Animation, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "webp.Decode")
+	}
+
+	anim, err := decode(data)
+
+	return anim, errors.Wrap(err, "webp.Decode")
+}
+
+func decode(data []byte) (*Animation, error) {
+	chunks, err := readChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(chunks) == 0 {
+		return nil, ErrInvalidFormat
+	}
+
+	if chunks[0].id != "VP8X" {
+		return decodeStill(data)
+	}
+
+	if len(chunks[0].data) < 10 {
+		return nil, ErrInvalidFormat
+	}
+
+	anim := &Animation{
+		Width:  int(uint24(chunks[0].data[4:])) + 1,
+		Height: int(uint24(chunks[0].data[7:])) + 1,
+	}
+
+	if int64(anim.Width)*int64(anim.Height) > maxCanvasPixels {
+		return nil, ErrTooLarge
+	}
+
+	var headers []frameHeader
+
+	for _, c := range chunks[1:] {
+		switch c.id {
+		case "ANIM":
+			if len(c.data) < 6 {
+				return nil, ErrInvalidFormat
+			}
+			anim.LoopCount = int(binary.LittleEndian.Uint16(c.data[4:]))
+		case "ANMF":
+			h, err := readFrameHeader(c.data)
+			if err != nil {
+				return nil, err
+			}
+			headers = append(headers, h)
+		}
+	}
+
+	// VP8X без анимации - обычная картинка с альфой или метаданными.
+	if len(headers) == 0 {
+		return decodeStill(data)
+	}
+
+	if int64(len(headers))*int64(anim.Width)*int64(anim.Height) > maxAnimationPixels {
+		return nil, ErrTooLarge
+	}
+
+	err = anim.compose(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return anim, nil
+}
+
+func decodeStill(data []byte) (*Animation, error) {
+	cfg, err := xwebp.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidFormat, err.Error())
+	}
+
+	if int64(cfg.Width)*int64(cfg.Height) > maxCanvasPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, err := xwebp.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalidFormat, err.Error())
+	}
+
+	canvas := toRGBA(img)
+
+	return &Animation{
+		Width:  canvas.Rect.Dx(),
+		Height: canvas.Rect.Dy(),
+		Frames: []Frame{{Image: canvas}},
+	}, nil
+}
+
+// compose renders frames following blending and disposal methods of each frame.
+// Every frame is an independent image, so there is no separate canvas: a frame starts as a copy
+// of the previous one with the disposed region cleared. The copy is skipped for frames
+// replacing the whole canvas.
+func (a *Animation) compose(headers []frameHeader) error {
+	canvasRect := image.Rect(0, 0, a.Width, a.Height)
+
+	var prev *frameHeader
+	var prevImg *image.RGBA
+
+	for i := range headers {
+		h := &headers[i]
+
+		img, err := decodeFrame(h)
+		if err != nil {
+			return errors.Wrapf(err, "frame %d", i)
+		}
+
+		if !h.rect.In(canvasRect) || img.Bounds().Size() != h.rect.Size() {
+			return errors.Wrapf(ErrInvalidFormat, "frame %d", i)
+		}
+
+		canvas := image.NewRGBA(canvasRect)
+
+		if prevImg != nil && !(h.noBlend && h.rect == canvasRect) {
+			copy(canvas.Pix, prevImg.Pix)
+
+			// новый буфер уже прозрачный, очищаем только область предыдущего кадра
+			if prev.dispose {
+				draw.Draw(canvas, prev.rect, image.Transparent, image.Point{}, draw.Src)
+			}
+		}
+
+		op := draw.Over
+		if h.noBlend {
+			op = draw.Src
+		}
+
+		draw.Draw(canvas, h.rect, img, img.Bounds().Min, op)
+
+		a.Frames = append(a.Frames, Frame{
+			Image: canvas,
+			Delay: h.delay,
+		})
+
+		prev, prevImg = h, canvas
+	}
+
+	return nil
+}
+
+// decodeFrame wraps frame bitstream into a standalone WebP container.
+func decodeFrame(h *frameHeader) (image.Image, error) {
+	var body bytes.Buffer
+
+	body.WriteString("WEBP")
+
+	hasAlpha := false
+	for _, c := range h.bitstream {
+		if c.id == "ALPH" {
+			hasAlpha = true
+		}
+	}
+
+	if hasAlpha {
+		vp8x := make([]byte, 10)
+		vp8x[0] = vp8xAlphaFlag
+		putUint24(vp8x[4:], uint32(h.rect.Dx()-1))
+		putUint24(vp8x[7:], uint32(h.rect.Dy()-1))
+		writeChunk(&body, "VP8X", vp8x)
+	}
+
+	for _, c := range h.bitstream {
+		writeChunk(&body, c.id, c.data)
+	}
+
+	var file bytes.Buffer
+	file.WriteString("RIFF")
+	_ = binary.Write(&file, binary.LittleEndian, uint32(body.Len()))
+	file.Write(body.Bytes())
+
+	img, err := xwebp.Decode(&file)
+	if err != nil {
+		// битый битстрим - это битый файл, а не внутренняя ошибка
+		return nil, errors.Wrap(ErrInvalidFormat, err.Error())
+	}
+
+	return img, nil
+}
+
+func readFrameHeader(data []byte) (frameHeader, error) {
+	if len(data) < anmfHeaderSize {
+		return frameHeader{}, ErrInvalidFormat
+	}
+
+	x := int(uint24(data[0:])) * 2
+	y := int(uint24(data[3:])) * 2
+	w := int(uint24(data[6:])) + 1
+	h := int(uint24(data[9:])) + 1
+	flags := data[15]
+
+	sub, err := parseChunks(data[anmfHeaderSize:])
+	if err != nil {
+		return frameHeader{}, err
+	}
+
+	header := frameHeader{
+		rect:    image.Rect(x, y, x+w, y+h),
+		delay:   time.Duration(uint24(data[12:])) * time.Millisecond,
+		noBlend: flags&anmfNoBlendFlag != 0,
+		dispose: flags&anmfDisposeFlag != 0,
+	}
+
+	for _, c := range sub {
+		switch c.id {
+		case "ALPH", "VP8 ", "VP8L":
+			header.bitstream = append(header.bitstream, c)
+		}
+	}
+
+	if len(header.bitstream) == 0 {
+		return frameHeader{}, ErrNoFrames
+	}
+
+	return header, nil
+}
+
+func readChunks(data []byte) ([]chunk, error) {
+	if len(data) < riffHeaderSize || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrInvalidFormat
+	}
+
+	size := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 4 || size+8 > len(data) {
+		return nil, ErrInvalidFormat
+	}
+
+	return parseChunks(data[riffHeaderSize : size+8])
+}
+
+func parseChunks(data []byte) ([]chunk, error) {
+	var chunks []chunk
+
+	for len(data) > 0 {
+		if len(data) < chunkHeaderSize {
+			return nil, ErrInvalidFormat
+		}
+
+		id := string(data[0:4])
+		size := int(binary.LittleEndian.Uint32(data[4:8]))
+		data = data[chunkHeaderSize:]
+
+		if size < 0 || size > len(data) {
+			return nil, ErrInvalidFormat
+		}
+
+		chunks = append(chunks, chunk{id: id, data: data[:size]})
+
+		// размер чанка выравнивается до четного
+		size += size & 1
+		data = data[min(size, len(data)):]
+	}
+
+	return chunks, nil
+}
+
+func writeChunk(w *bytes.Buffer, id string, data []byte) {
+	w.WriteString(id)
+	_ = binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+
+	if len(data)&1 == 1 {
+		w.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if res, ok := img.(*image.RGBA); ok && res.Rect.Min == (image.Point{}) {
+		return res
+	}
+
+	b := img.Bounds()
+	res := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(res, res.Rect, img, b.Min, draw.Src)
+
+	return res
+}
@@ -0,0 +1,423 @@
+package webp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/color"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	red         = color.NRGBA{R: 255, A: 255}
+	blue        = color.NRGBA{B: 255, A: 255}
+	halfGreen   = color.NRGBA{G: 255, A: 128}
+	transparent = color.NRGBA{}
+)
+
+// bitWriter writes VP8L bits starting from the least significant one.
+type bitWriter struct {
+	buf   []byte
+	nbits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	for i := uint(0); i < n; i++ {
+		if w.nbits%8 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		w.buf[len(w.buf)-1] |= byte(v>>i&1) << (w.nbits % 8)
+		w.nbits++
+	}
+}
+
+// solidVP8L returns lossless bitstream of a single colour image. Every prefix code has a single symbol,
+// so pixels take no bits at all.
+func solidVP8L(width, height int, c color.NRGBA) []byte {
+	w := &bitWriter{buf: []byte{0x2f}, nbits: 8}
+
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	w.write(1, 1) // alpha is used
+	w.write(0, 3) // version
+	w.write(0, 1) // no transforms
+	w.write(0, 1) // no color cache
+	w.write(0, 1) // no meta prefix codes
+
+	// green, red, blue, alpha
+	for _, v := range []uint8{c.G, c.R, c.B, c.A} {
+		w.write(1, 1) // simple code
+		w.write(0, 1) // one symbol
+		w.write(1, 1) // 8 bit symbol
+		w.write(uint32(v), 8)
+	}
+
+	// distance
+	w.write(1, 1)
+	w.write(0, 1)
+	w.write(0, 1)
+	w.write(0, 1)
+
+	return w.buf
+}
+
+func chunkBytes(id string, data []byte) []byte {
+	var b bytes.Buffer
+	writeChunk(&b, id, data)
+
+	return b.Bytes()
+}
+
+func riff(chunks ...[]byte) []byte {
+	body := []byte("WEBP")
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+
+	res := []byte("RIFF")
+	res = binary.LittleEndian.AppendUint32(res, uint32(len(body)))
+
+	return append(res, body...)
+}
+
+func vp8x(width, height int) []byte {
+	data := make([]byte, 10)
+	data[0] = 1<<1 | vp8xAlphaFlag // animation and alpha
+	putUint24(data[4:], uint32(width-1))
+	putUint24(data[7:], uint32(height-1))
+
+	return chunkBytes("VP8X", data)
+}
+
+func anim(loops int) []byte {
+	data := make([]byte, 6)
+	binary.LittleEndian.PutUint16(data[4:], uint16(loops))
+
+	return chunkBytes("ANIM", data)
+}
+
+type frameSpec struct {
+	x, y, w, h int
+	delayMs    int
+	noBlend    bool
+	dispose    bool
+	c          color.NRGBA
+}
+
+func anmf(f frameSpec) []byte {
+	data := make([]byte, anmfHeaderSize)
+	putUint24(data[0:], uint32(f.x/2))
+	putUint24(data[3:], uint32(f.y/2))
+	putUint24(data[6:], uint32(f.w-1))
+	putUint24(data[9:], uint32(f.h-1))
+	putUint24(data[12:], uint32(f.delayMs))
+
+	if f.noBlend {
+		data[15] |= anmfNoBlendFlag
+	}
+	if f.dispose {
+		data[15] |= anmfDisposeFlag
+	}
+
+	data = append(data, chunkBytes("VP8L", solidVP8L(f.w, f.h, f.c))...)
+
+	return chunkBytes("ANMF", data)
+}
+
+func animated(width, height int, frames ...frameSpec) []byte {
+	chunks := [][]byte{vp8x(width, height), anim(0)}
+	for _, f := range frames {
+		chunks = append(chunks, anmf(f))
+	}
+
+	return riff(chunks...)
+}
+
+func pixel(t *testing.T, a *Animation, frame, x, y int) color.NRGBA {
+	t.Helper()
+
+	return color.NRGBAModel.Convert(a.Frames[frame].Image.At(x, y)).(color.NRGBA)
+}
+
+func TestDecodeStill(t *testing.T) {
+	a, err := Decode(bytes.NewReader(riff(chunkBytes("VP8L", solidVP8L(3, 2, red)))))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if a.Width != 3 || a.Height != 2 || len(a.Frames) != 1 || a.Frames[0].Delay != 0 {
+		t.Fatalf("Decode() = %dx%d, %d frames, want 3x2 with one frame without delay", a.Width, a.Height, len(a.Frames))
+	}
+
+	if got := pixel(t, a, 0, 2, 1); got != red {
+		t.Errorf("pixel = %v, want %v", got, red)
+	}
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	valid := animated(4, 4, frameSpec{w: 4, h: 4, delayMs: 50, c: red})
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrInvalidFormat},
+		{"not riff", append([]byte("RIFX"), valid[4:]...), ErrInvalidFormat},
+		{"truncated file", valid[:len(valid)-10], ErrInvalidFormat},
+		{
+			"truncated chunk",
+			riff(vp8x(4, 4), append([]byte("ANMF"), 0xff, 0, 0, 0, 1, 2, 3)),
+			ErrInvalidFormat,
+		},
+		{"short vp8x", riff(chunkBytes("VP8X", make([]byte, 4))), ErrInvalidFormat},
+		{"short anim", riff(vp8x(4, 4), chunkBytes("ANIM", make([]byte, 2))), ErrInvalidFormat},
+		{"short anmf header", riff(vp8x(4, 4), anim(0), chunkBytes("ANMF", make([]byte, 8))), ErrInvalidFormat},
+		{
+			"anmf without bitstream",
+			riff(vp8x(4, 4), anim(0), chunkBytes("ANMF", make([]byte, anmfHeaderSize))),
+			ErrNoFrames,
+		},
+		{"corrupt still bitstream", riff(chunkBytes("VP8L", []byte{0x2f, 0xff, 0xff, 0xff, 0xff})), ErrInvalidFormat},
+		{
+			"corrupt frame bitstream",
+			riff(vp8x(4, 4), anim(0), func() []byte {
+				f := anmf(frameSpec{w: 4, h: 4, c: red})
+				// портим битстрим после сигнатуры VP8L
+				for i := chunkHeaderSize + anmfHeaderSize + chunkHeaderSize + 5; i < len(f); i++ {
+					f[i] = 0xff
+				}
+
+				return f
+			}()),
+			ErrInvalidFormat,
+		},
+		{"canvas too large", riff(vp8x(1<<14, 1<<14), anim(0)), ErrTooLarge},
+		{
+			"too many frames",
+			animated(1<<13, 1<<13, frameSpec{w: 1, h: 1, c: red}, frameSpec{w: 1, h: 1, c: red}, frameSpec{w: 1, h: 1, c: red}),
+			ErrTooLarge,
+		},
+		{
+			"frame outside canvas",
+			animated(4, 4, frameSpec{x: 2, y: 2, w: 4, h: 4, delayMs: 50, c: red}),
+			ErrInvalidFormat,
+		},
+		{
+			"frame size mismatch",
+			riff(vp8x(4, 4), anim(0), func() []byte {
+				f := anmf(frameSpec{w: 2, h: 2, c: red})
+				// заголовок говорит 3x3, а битстрим 2x2
+				putUint24(f[chunkHeaderSize+6:], 2)
+				putUint24(f[chunkHeaderSize+9:], 2)
+
+				return f
+			}()),
+			ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode(bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Decode() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeAnimation(t *testing.T) {
+	data := riff(
+		vp8x(4, 2),
+		anim(3),
+		anmf(frameSpec{w: 4, h: 2, delayMs: 40, c: red}),
+		anmf(frameSpec{w: 2, h: 2, delayMs: 1500, c: blue}),
+	)
+
+	a, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if a.LoopCount != 3 {
+		t.Errorf("LoopCount = %d, want 3", a.LoopCount)
+	}
+
+	want := []time.Duration{40 * time.Millisecond, 1500 * time.Millisecond}
+	if len(a.Frames) != len(want) {
+		t.Fatalf("got %d frames, want %d", len(a.Frames), len(want))
+	}
+
+	for i := range want {
+		if a.Frames[i].Delay != want[i] {
+			t.Errorf("frame %d delay = %s, want %s", i, a.Frames[i].Delay, want[i])
+		}
+	}
+
+	if a.Duration() != 1540*time.Millisecond {
+		t.Errorf("Duration() = %s, want 1.54s", a.Duration())
+	}
+}
+
+func TestDecodeMissingAnim(t *testing.T) {
+	// без ANIM анимация играет бесконечно, как в браузерах
+	data := riff(vp8x(2, 2), anmf(frameSpec{w: 2, h: 2, delayMs: 100, c: red}))
+
+	a, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	if a.LoopCount != 0 || len(a.Frames) != 1 {
+		t.Errorf("Decode() loop count = %d, frames = %d, want 0 and 1", a.LoopCount, len(a.Frames))
+	}
+}
+
+func TestCompose(t *testing.T) {
+	type check struct {
+		frame, x, y int
+		want        color.NRGBA
+	}
+
+	tests := []struct {
+		name   string
+		width  int
+		height int
+		frames []frameSpec
+		checks []check
+	}{
+		{
+			name:  "blend",
+			width: 4, height: 4,
+			frames: []frameSpec{
+				{w: 4, h: 4, c: red},
+				{w: 2, h: 2, c: transparent},
+			},
+			checks: []check{
+				{1, 0, 0, red},
+				{1, 3, 3, red},
+			},
+		},
+		{
+			name:  "no blend",
+			width: 4, height: 4,
+			frames: []frameSpec{
+				{w: 4, h: 4, c: red},
+				{w: 2, h: 2, noBlend: true, c: transparent},
+			},
+			checks: []check{
+				{0, 0, 0, red},
+				{1, 0, 0, transparent},
+				{1, 1, 1, transparent},
+				{1, 2, 2, red},
+			},
+		},
+		{
+			name:  "semi-transparent blend",
+			width: 2, height: 2,
+			frames: []frameSpec{
+				{w: 2, h: 2, c: blue},
+				{w: 2, h: 2, c: halfGreen},
+			},
+			checks: []check{
+				{1, 0, 0, color.NRGBA{G: 128, B: 127, A: 255}},
+			},
+		},
+		{
+			name:  "full canvas no blend replaces everything",
+			width: 2, height: 2,
+			frames: []frameSpec{
+				{w: 2, h: 2, c: red},
+				{w: 2, h: 2, noBlend: true, c: halfGreen},
+			},
+			checks: []check{
+				{1, 1, 1, halfGreen},
+			},
+		},
+		{
+			name:  "dispose to background",
+			width: 4, height: 4,
+			frames: []frameSpec{
+				{w: 4, h: 4, c: red},
+				{w: 2, h: 2, dispose: true, c: blue},
+				{x: 2, y: 2, w: 2, h: 2, c: blue},
+			},
+			checks: []check{
+				{1, 0, 0, blue},
+				{1, 3, 3, red},
+				// область кадра 1 очищена, остальной холст остался
+				{2, 0, 0, transparent},
+				{2, 1, 1, transparent},
+				{2, 0, 3, red},
+				{2, 3, 3, blue},
+			},
+		},
+		{
+			name:  "no dispose keeps previous frame",
+			width: 4, height: 4,
+			frames: []frameSpec{
+				{w: 2, h: 2, c: red},
+				{x: 2, y: 2, w: 2, h: 2, c: blue},
+			},
+			checks: []check{
+				{1, 0, 0, red},
+				{1, 3, 3, blue},
+				{1, 3, 0, transparent},
+			},
+		},
+		{
+			name:  "odd frame size at offset",
+			width: 9, height: 7,
+			frames: []frameSpec{
+				// смещения хранятся деленными на 2, нечетными бывают только размеры
+				{x: 4, y: 2, w: 5, h: 3, c: red},
+			},
+			checks: []check{
+				{0, 3, 2, transparent},
+				{0, 4, 2, red},
+				{0, 8, 4, red},
+				{0, 8, 5, transparent},
+				{0, 4, 1, transparent},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Decode(bytes.NewReader(animated(tt.width, tt.height, tt.frames...)))
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+
+			if len(a.Frames) != len(tt.frames) {
+				t.Fatalf("got %d frames, want %d", len(a.Frames), len(tt.frames))
+			}
+
+			for _, c := range tt.checks {
+				if got := pixel(t, a, c.frame, c.x, c.y); got != c.want {
+					t.Errorf("frame %d pixel (%d, %d) = %v, want %v", c.frame, c.x, c.y, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestComposeFramesAreIndependent(t *testing.T) {
+	a, err := Decode(bytes.NewReader(animated(
+		2, 2,
+		frameSpec{w: 2, h: 2, c: red},
+		frameSpec{w: 2, h: 2, noBlend: true, c: blue},
+	)))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+
+	a.Frames[1].Image.Set(0, 0, transparent)
+
+	if got := pixel(t, a, 0, 0, 0); got != red {
+		t.Errorf("first frame changed with the second one: %v", got)
+	}
+}
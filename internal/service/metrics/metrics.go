@@ -0,0 +1,17 @@
+package metrics
+
+import "sync/atomic"
+
+// Metrics are in-memory counters of the current process.
+type Metrics struct {
+	UpdatePanics   atomic.Int64
+	JobPanics      atomic.Int64
+	WorkerRestarts atomic.Int64
+
+	Encodes        atomic.Int64
+	EncodeAttempts atomic.Int64
+}
+
+func New() *Metrics {
+	return &Metrics{}
+}
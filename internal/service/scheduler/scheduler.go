@@ -0,0 +1,198 @@
+package scheduler
+
+import (
+	"slices"
+	"sync"
+
+	"seventv2tg/internal/domain"
+)
+
+type Class int
+
+// Classes are served strictly in this order, users of the same class are served round-robin.
+const (
+	ClassAdmin Class = iota
+	ClassPriority
+	ClassDefault
+
+	classesCount
+)
+
+type (
+	userQueue struct {
+		// users with pending requests in the order of their next turn
+		order    []int64
+		requests map[int64][]domain.UserRequest
+	}
+
+	Scheduler struct {
+		adminIDs    []int64
+		priorityIDs []int64
+
+		classes [classesCount]*userQueue
+		size    int
+
+		mu   sync.Mutex
+		cond *sync.Cond
+	}
+)
+
+func New(adminIDs, priorityIDs []int64) *Scheduler {
+	s := &Scheduler{
+		adminIDs:    adminIDs,
+		priorityIDs: priorityIDs,
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	for i := range s.classes {
+		s.classes[i] = newUserQueue()
+	}
+
+	return s
+}
+
+func (s *Scheduler) ClassOf(userID int64) Class {
+	switch {
+	case slices.Contains(s.adminIDs, userID):
+		return ClassAdmin
+	case slices.Contains(s.priorityIDs, userID):
+		return ClassPriority
+	default:
+		return ClassDefault
+	}
+}
+
+// Push adds request to the queue and returns its position in the serving order starting from 1.
+func (s *Scheduler) Push(req domain.UserRequest) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.classes[s.ClassOf(req.UserID)].push(req)
+	s.size++
+	s.cond.Signal()
+
+	return s.position(req.JobID)
+}
+
+// Pop blocks until there is a request to process. claim is called with the request under the queue lock,
+// so the request is never seen as neither queued nor claimed, e.g. by Remove.
+func (s *Scheduler) Pop(claim func(domain.UserRequest)) domain.UserRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for s.size == 0 {
+		s.cond.Wait()
+	}
+
+	s.size--
+
+	req, _ := s.pop()
+	claim(req)
+
+	return req
+}
+
+// Remove drops all queued requests of the user and returns them.
+func (s *Scheduler) Remove(userID int64) []domain.UserRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	removed := s.classes[s.ClassOf(userID)].remove(userID)
+	s.size -= len(removed)
+
+	return removed
+}
+
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.size
+}
+
+func (s *Scheduler) pop() (domain.UserRequest, bool) {
+	for _, q := range s.classes {
+		if req, ok := q.pop(); ok {
+			return req, true
+		}
+	}
+
+	return domain.UserRequest{}, false
+}
+
+// position replays the serving order on a copy of the queue.
+func (s *Scheduler) position(jobID uint64) int {
+	replay := &Scheduler{}
+	for i, q := range s.classes {
+		replay.classes[i] = q.clone()
+	}
+
+	for pos := 1; ; pos++ {
+		req, ok := replay.pop()
+		if !ok {
+			return 0
+		}
+
+		if req.JobID == jobID {
+			return pos
+		}
+	}
+}
+
+func newUserQueue() *userQueue {
+	return &userQueue{requests: make(map[int64][]domain.UserRequest)}
+}
+
+func (q *userQueue) push(req domain.UserRequest) {
+	if len(q.requests[req.UserID]) == 0 {
+		q.order = append(q.order, req.UserID)
+	}
+
+	q.requests[req.UserID] = append(q.requests[req.UserID], req)
+}
+
+func (q *userQueue) pop() (domain.UserRequest, bool) {
+	if len(q.order) == 0 {
+		return domain.UserRequest{}, false
+	}
+
+	userID := q.order[0]
+	q.order = q.order[1:]
+
+	pending := q.requests[userID]
+	req := pending[0]
+
+	if len(pending) == 1 {
+		delete(q.requests, userID)
+	} else {
+		q.requests[userID] = pending[1:]
+		q.order = append(q.order, userID)
+	}
+
+	return req, true
+}
+
+func (q *userQueue) remove(userID int64) []domain.UserRequest {
+	removed := q.requests[userID]
+	if len(removed) == 0 {
+		return nil
+	}
+
+	delete(q.requests, userID)
+	q.order = slices.DeleteFunc(q.order, func(id int64) bool { return id == userID })
+
+	return removed
+}
+
+func (q *userQueue) clone() *userQueue {
+	c := &userQueue{
+		order:    slices.Clone(q.order),
+		requests: make(map[int64][]domain.UserRequest, len(q.requests)),
+	}
+
+	for userID, pending := range q.requests {
+		c.requests[userID] = slices.Clone(pending)
+	}
+
+	return c
+}